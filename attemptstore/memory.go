@@ -0,0 +1,65 @@
+package attemptstore
+
+import (
+	"sync"
+	"time"
+)
+
+// Keeps counters in process memory. Counters are lost on restart and are not
+// shared between server instances, use RedisStore for that.
+type MemoryStore struct {
+	mu sync.Mutex
+	counters map[string]Counter
+}
+
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	store := &MemoryStore{counters: map[string]Counter{}}
+	go store._CleanUp(cleanupInterval)
+	return store
+}
+
+func (s *MemoryStore) Increment(key string, ttl time.Duration) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counter := s._Get(key)
+	if counter.Count == 0 {
+		counter.ExpiresAt = time.Now().Add(ttl)
+	}
+	counter.Count++
+	s.counters[key] = counter
+	return counter, nil
+}
+
+func (s *MemoryStore) Get(key string) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s._Get(key), nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryStore) _Get(key string) Counter {
+	counter, ok := s.counters[key]
+	if !ok || !time.Now().Before(counter.ExpiresAt) {
+		return Counter{}
+	}
+	return counter
+}
+
+func (s *MemoryStore) _CleanUp(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		now := time.Now()
+		for key, counter := range s.counters {
+			if !now.Before(counter.ExpiresAt) {
+				delete(s.counters, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
\ No newline at end of file
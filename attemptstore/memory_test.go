@@ -0,0 +1,24 @@
+package attemptstore
+
+import (
+	"testing"
+	"time"
+)
+
+// One increment per window mustn't keep the count alive, the limits are rates.
+func TestMemoryStoreCountsPerWindow(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	const window = 100 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		for want := int64(1); want <= 2; want++ {
+			counter, err := store.Increment("stuffing:global", window)
+			if err != nil {
+				t.Fatalf("Increment: %v", err)
+			}
+			if counter.Count != want {
+				t.Errorf("Increment %d of window %d returned count %d, want %d", want, i, counter.Count, want)
+			}
+			time.Sleep(window * 6 / 10)
+		}
+	}
+}
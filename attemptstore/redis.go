@@ -0,0 +1,76 @@
+package attemptstore
+
+import (
+	"github.com/go-redis/redis/v8"
+	"context"
+	"time"
+)
+
+// Keeps counters in any server speaking the Redis protocol, so every server
+// instance sees the same counts. Expiry is left to the server via PEXPIRE.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(options *redis.Options, prefix string) (*RedisStore, error) {
+	client := redis.NewClient(options)
+	err := client.Ping(context.Background()).Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+// Sets the expiry only when the key has none, i.e. INCR just created it, and
+// returns the count with the time left.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+  redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+func (s *RedisStore) Increment(key string, ttl time.Duration) (Counter, error) {
+	result, err := incrementScript.Run(context.Background(), s.client, []string{s.prefix+key}, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Counter{}, err
+	}
+	return Counter{Count: result[0], ExpiresAt: time.Now().Add(time.Duration(result[1]) * time.Millisecond)}, nil
+}
+
+func (s *RedisStore) Get(key string) (Counter, error) {
+	ctx := context.Background()
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, s.prefix+key)
+		pttl = pipe.PTTL(ctx, s.prefix+key)
+		return nil
+	})
+	if err == redis.Nil {
+		return Counter{}, nil
+	}
+	if err != nil {
+		return Counter{}, err
+	}
+	count, err := get.Int64()
+	if err != nil {
+		return Counter{}, err
+	}
+	// a negative PTTL means the key has no expiry or vanished in between
+	if pttl.Val() <= 0 {
+		return Counter{}, nil
+	}
+	return Counter{Count: count, ExpiresAt: time.Now().Add(pttl.Val())}, nil
+}
+
+func (s *RedisStore) Reset(key string) error {
+	return s.client.Del(context.Background(), s.prefix+key).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
\ No newline at end of file
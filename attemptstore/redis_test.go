@@ -0,0 +1,158 @@
+package attemptstore
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"testing"
+	"time"
+)
+
+func _NewTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore(&redis.Options{Addr: server.Addr()}, "auth:")
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store, server
+}
+
+func TestRedisStoreIncrement(t *testing.T) {
+	store, server := _NewTestRedisStore(t)
+	for want := int64(1); want <= 3; want++ {
+		counter, err := store.Increment("login:a@example.com", time.Minute)
+		if err != nil {
+			t.Fatalf("Increment: %v", err)
+		}
+		if counter.Count != want {
+			t.Errorf("Increment returned count %d, want %d", counter.Count, want)
+		}
+	}
+	if !server.Exists("auth:login:a@example.com") {
+		t.Errorf("Increment didn't store the counter under the prefix")
+	}
+	if ttl := server.TTL("auth:login:a@example.com"); ttl != time.Minute {
+		t.Errorf("counter TTL is %v, want %v", ttl, time.Minute)
+	}
+}
+
+func TestRedisStoreGet(t *testing.T) {
+	store, _ := _NewTestRedisStore(t)
+	counter, err := store.Get("login:missing@example.com")
+	if err != nil {
+		t.Fatalf("Get of a missing key: %v", err)
+	}
+	if counter != (Counter{}) {
+		t.Errorf("Get of a missing key returned %+v, want a zero Counter", counter)
+	}
+	store.Increment("login:a@example.com", time.Minute)
+	store.Increment("login:a@example.com", time.Minute)
+	counter, err = store.Get("login:a@example.com")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if counter.Count != 2 {
+		t.Errorf("Get returned count %d, want 2", counter.Count)
+	}
+	if left := time.Until(counter.ExpiresAt); left <= 0 || left > time.Minute {
+		t.Errorf("Get returned an expiry %v from now, want within a minute", left)
+	}
+}
+
+func TestRedisStoreReset(t *testing.T) {
+	store, server := _NewTestRedisStore(t)
+	store.Increment("login:a@example.com", time.Minute)
+	err := store.Reset("login:a@example.com")
+	if err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if server.Exists("auth:login:a@example.com") {
+		t.Errorf("Reset left the counter in Redis")
+	}
+	counter, _ := store.Get("login:a@example.com")
+	if counter.Count != 0 {
+		t.Errorf("Get after Reset returned count %d, want 0", counter.Count)
+	}
+	err = store.Reset("login:missing@example.com")
+	if err != nil {
+		t.Errorf("Reset of a missing key: %v", err)
+	}
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	store, server := _NewTestRedisStore(t)
+	store.Increment("login:a@example.com", time.Minute)
+	server.FastForward(30 * time.Second)
+	counter, _ := store.Increment("login:a@example.com", time.Minute)
+	if left := time.Until(counter.ExpiresAt); left > 30 * time.Second {
+		t.Errorf("Increment returned an expiry %v from now, want the window's first 30s", left)
+	}
+	server.FastForward(15 * time.Second)
+	counter, _ = store.Get("login:a@example.com")
+	if counter.Count != 2 {
+		t.Errorf("Get before expiry returned count %d, want 2", counter.Count)
+	}
+	server.FastForward(15 * time.Second)
+	counter, err := store.Get("login:a@example.com")
+	if err != nil {
+		t.Fatalf("Get of an expired key: %v", err)
+	}
+	if counter != (Counter{}) {
+		t.Errorf("Get of an expired key returned %+v, want a zero Counter", counter)
+	}
+	counter, _ = store.Increment("login:a@example.com", time.Minute)
+	if counter.Count != 1 {
+		t.Errorf("Increment after expiry returned count %d, want 1", counter.Count)
+	}
+}
+
+// One increment per window mustn't keep the count alive, the limits are rates.
+func TestRedisStoreCountsPerWindow(t *testing.T) {
+	store, server := _NewTestRedisStore(t)
+	for window := 0; window < 3; window++ {
+		for want := int64(1); want <= 2; want++ {
+			counter, err := store.Increment("stuffing:global", time.Minute)
+			if err != nil {
+				t.Fatalf("Increment: %v", err)
+			}
+			if counter.Count != want {
+				t.Errorf("Increment %d of window %d returned count %d, want %d", want, window, counter.Count, want)
+			}
+			server.FastForward(40 * time.Second)
+		}
+	}
+}
+
+func TestRedisStoreConcurrentIncrements(t *testing.T) {
+	store, _ := _NewTestRedisStore(t)
+	const attempts = 50
+	counts := make(chan int64, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter, err := store.Increment("login:a@example.com", time.Minute)
+			if err != nil {
+				t.Errorf("Increment: %v", err)
+				return
+			}
+			counts <- counter.Count
+		}()
+	}
+	wg.Wait()
+	close(counts)
+	seen := map[int64]bool{}
+	for count := range counts {
+		if seen[count] {
+			t.Errorf("two increments returned count %d", count)
+		}
+		seen[count] = true
+	}
+	if len(seen) != attempts {
+		t.Errorf("got %d distinct counts, want %d", len(seen), attempts)
+	}
+}
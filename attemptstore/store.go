@@ -0,0 +1,21 @@
+package attemptstore
+
+import (
+	"time"
+)
+
+// Counts attempts per key (e.g. failed logins of an email). A counter disappears
+// once it expires, so keys for unknown emails don't pile up.
+type Store interface {
+	// Adds one to key. A new key expires ttl from now, incrementing doesn't push
+	// the expiry out, so the count is per fixed window.
+	Increment(key string, ttl time.Duration) (Counter, error)
+	// Returns a zero Counter when key is missing or expired.
+	Get(key string) (Counter, error)
+	Reset(key string) error
+}
+
+type Counter struct {
+	Count int64
+	ExpiresAt time.Time
+}
\ No newline at end of file
@@ -0,0 +1,101 @@
+package captcha
+
+import (
+	"net/http"
+	"net/url"
+	"encoding/json"
+	"errors"
+	"time"
+	"fmt"
+)
+
+const HCAPTCHA = "hcaptcha"
+const RECAPTCHA = "recaptcha"
+const TURNSTILE = "turnstile"
+
+const HCAPTCHA_VERIFY_URL = "https://api.hcaptcha.com/siteverify"
+const RECAPTCHA_VERIFY_URL = "https://www.google.com/recaptcha/api/siteverify"
+const TURNSTILE_VERIFY_URL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+type CaptchaVerifier interface {
+	// Reports whether token is a solved challenge. remoteIP is optional.
+	Verify(token, remoteIP string) (bool, error)
+}
+
+// hCaptcha, reCAPTCHA and Turnstile all check tokens with the same siteverify
+// request, only the URL differs.
+type SiteVerifier struct {
+	VerifyURL string
+	Secret string
+	Client *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success bool `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+func NewHCaptchaVerifier(secret string) *SiteVerifier {
+	return _NewSiteVerifier(HCAPTCHA_VERIFY_URL, secret)
+}
+
+func NewReCaptchaVerifier(secret string) *SiteVerifier {
+	return _NewSiteVerifier(RECAPTCHA_VERIFY_URL, secret)
+}
+
+func NewTurnstileVerifier(secret string) *SiteVerifier {
+	return _NewSiteVerifier(TURNSTILE_VERIFY_URL, secret)
+}
+
+// Returns nil when provider is empty, meaning captchas are disabled.
+func NewVerifier(provider, secret string) (CaptchaVerifier, error) {
+	if len(provider) == 0 {
+		return nil, nil
+	}
+	if len(secret) == 0 {
+		return nil, errors.New(fmt.Sprintf("Missing secret for captcha provider %q.", provider))
+	}
+	switch provider {
+	case HCAPTCHA:
+		return NewHCaptchaVerifier(secret), nil
+	case RECAPTCHA:
+		return NewReCaptchaVerifier(secret), nil
+	case TURNSTILE:
+		return NewTurnstileVerifier(secret), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown captcha provider %q.", provider))
+}
+
+func (v *SiteVerifier) Verify(token, remoteIP string) (bool, error) {
+	if len(token) == 0 {
+		return false, nil
+	}
+	form := url.Values{}
+	form.Set("secret", v.Secret)
+	form.Set("response", token)
+	if len(remoteIP) > 0 {
+		form.Set("remoteip", remoteIP)
+	}
+	response, err := v.Client.PostForm(v.VerifyURL, form)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, errors.New(fmt.Sprintf("Captcha verification returned %s.", response.Status))
+	}
+	var body siteVerifyResponse
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return false, err
+	}
+	return body.Success, nil
+}
+
+func _NewSiteVerifier(verifyURL, secret string) *SiteVerifier {
+	return &SiteVerifier{
+		VerifyURL: verifyURL,
+		Secret: secret,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
\ No newline at end of file
@@ -0,0 +1,19 @@
+package captcha
+
+// Accepts exactly the tokens in ValidTokens, for tests and local demos.
+type FakeVerifier struct {
+	ValidTokens []string
+	Err error
+}
+
+func (v *FakeVerifier) Verify(token, remoteIP string) (bool, error) {
+	if v.Err != nil {
+		return false, v.Err
+	}
+	for _, validToken := range v.ValidTokens {
+		if token == validToken {
+			return true, nil
+		}
+	}
+	return false, nil
+}
\ No newline at end of file
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/openapi"
+	"github.com/shoppingapp/apiv1/routes"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: authctl [-env FILE] [-config FILE] <command> [flags]
+
+Commands:
+  create-user      Create a user (-email, -display-name, -password)
+  reset-password   Set a user's password and revoke their sessions (-email, -password)
+  unlock           Clear login and password reset bans for an email (-email)
+  revoke-sessions  Delete every refresh token of a user (-email)
+  set-admin        Let a user query the audit log, or stop them with -revoke (-email)
+  audit            Check the audit log's hash chain and checkpoints, or sign a checkpoint now (verify or checkpoint)
+  openapi          Print the OpenAPI document, or check it matches the routes (print or check)
+  migrate          Show or change the schema version (status, up, down or to VERSION)
+  generate-key     Print a new base64 JWT secret, or an audit log key pair (-type)
+  rotate-keys      Move the current JWT secrets to *_OLD and write new ones (-type)
+
+If -password is omitted it is read from stdin.
+`
+
+var envFile string
+var configFile string
+var validate *validator.Validate
+
+func main() {
+	flag.StringVar(&envFile, "env", ".env", "environment file to load")
+	flag.StringVar(&configFile, "config", os.Getenv(config.CONFIG_FILE), "YAML config file")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	validate = validator.New()
+	command, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch command {
+	case "create-user":
+		err = CreateUser(args)
+	case "reset-password":
+		err = ResetPassword(args)
+	case "unlock":
+		err = Unlock(args)
+	case "revoke-sessions":
+		err = RevokeSessions(args)
+	case "set-admin":
+		err = SetAdmin(args)
+	case "audit":
+		err = Audit(args)
+	case "openapi":
+		err = OpenAPI(args)
+	case "migrate":
+		err = Migrate(args)
+	case "generate-key":
+		err = GenerateKey(args)
+	case "rotate-keys":
+		err = RotateKeys(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func CreateUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	email := fs.String("email", "", "email of the new user")
+	displayName := fs.String("display-name", "", "display name of the new user")
+	password := fs.String("password", "", "password of the new user")
+	fs.Parse(args)
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	passwordString, err := _PasswordOrPrompt(*password)
+	if err != nil {
+		return err
+	}
+	err = validate.Struct(routes.SignupAttempt{
+		Email: *email,
+		DisplayName: *displayName,
+		Password: passwordString,
+		ConfirmPassword: passwordString,
+	})
+	if err != nil {
+		return err
+	}
+	passwordHash, err := utils.HashPassword(passwordString)
+	if err != nil {
+		return err
+	}
+	err = dbhelper.AdminCreateUser(*email, *displayName, passwordHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created user %s.\n", *email)
+	return nil
+}
+
+func ResetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	password := fs.String("password", "", "new password")
+	fs.Parse(args)
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	passwordString, err := _PasswordOrPrompt(*password)
+	if err != nil {
+		return err
+	}
+	err = validate.Struct(routes.PasswordResetAttempt{
+		Email: *email,
+		Code: "authctl",
+		Password: passwordString,
+		ConfirmPassword: passwordString,
+	})
+	if err != nil {
+		return err
+	}
+	passwordHash, err := utils.HashPassword(passwordString)
+	if err != nil {
+		return err
+	}
+	err = dbhelper.AdminSetPassword(*email, passwordHash)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reset the password of %s and revoked their sessions.\n", *email)
+	return nil
+}
+
+func Unlock(args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	email := fs.String("email", "", "email to unlock")
+	fs.Parse(args)
+	if len(*email) == 0 {
+		return errors.New("-email is required")
+	}
+	cfg, err := _LoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.AttemptStore.Backend == config.ATTEMPT_STORE_MEMORY {
+		return errors.New("The server keeps attempt counters in memory, restart it to clear them.")
+	}
+	if err := dbhelper.OpenAttemptStore(cfg.AttemptStore); err != nil {
+		return err
+	}
+	err = dbhelper.AdminUnlockAccount(*email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Unlocked %s.\n", *email)
+	return nil
+}
+
+func RevokeSessions(args []string) error {
+	fs := flag.NewFlagSet("revoke-sessions", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	fs.Parse(args)
+	if len(*email) == 0 {
+		return errors.New("-email is required")
+	}
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	numRevoked, err := dbhelper.AdminRevokeSessions(*email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %d session(s) of %s.\n", numRevoked, *email)
+	return nil
+}
+
+func SetAdmin(args []string) error {
+	fs := flag.NewFlagSet("set-admin", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	revoke := fs.Bool("revoke", false, "remove admin rights instead of granting them")
+	fs.Parse(args)
+	if len(*email) == 0 {
+		return errors.New("-email is required")
+	}
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	err := dbhelper.AdminSetIsAdmin(*email, !*revoke)
+	if err != nil {
+		return err
+	}
+	if *revoke {
+		fmt.Printf("%s is no longer an admin.\n", *email)
+	} else {
+		fmt.Printf("%s is now an admin.\n", *email)
+	}
+	return nil
+}
+
+func Audit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	minCheckpoint := fs.Uint("checkpoint", 0, "verify: the latest checkpoint known to exist, e.g. from the server log")
+	fs.Parse(args)
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	action := "verify"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	switch action {
+	case "verify":
+		result, err := dbhelper.VerifyAuditLog(*minCheckpoint)
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Verification failed after %d intact events: %v",
+				result.Events,
+				err,
+			))
+		}
+		fmt.Printf("Verified %d events and %d checkpoints, the audit log is intact.\n", result.Events, result.Checkpoints)
+		fmt.Printf(
+			"The latest checkpoint is %d, %d events after it aren't signed yet. Pass -checkpoint %d next time to catch deleted checkpoints.\n",
+			result.LatestCheckpoint,
+			result.Unsigned,
+			result.LatestCheckpoint,
+		)
+	case "checkpoint":
+		checkpoint, created, err := dbhelper.CreateAuditCheckpoint()
+		if err != nil {
+			return err
+		}
+		if !created {
+			fmt.Println("Nothing new to checkpoint.")
+			return nil
+		}
+		fmt.Printf("Signed checkpoint %d at event %d.\n", checkpoint.Number, checkpoint.Seq)
+	default:
+		return errors.New("audit takes verify or checkpoint")
+	}
+	return nil
+}
+
+// Builds the server's routes with the default config, without a database or .env,
+// so CI can run check to catch routes missing from routes.Operations or changes
+// to the API that routes/openapi.json doesn't have yet.
+func OpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+	fs.Parse(args)
+	cfg := config.Default()
+	r := mux.NewRouter()
+	err := routes.CreateRoutes(r, &cfg)
+	if err != nil {
+		return err
+	}
+	action := "print"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	switch action {
+	case "print":
+		fmt.Println(string(routes.OpenAPIDocument()))
+	case "check":
+		err = openapi.Check(r, routes.Operations, routes.PublishedOpenAPI)
+		if err != nil {
+			return errors.New(err.Error() + " Run authctl openapi print > routes/openapi.json if the change is meant.")
+		}
+		fmt.Printf("The OpenAPI document covers all %d routes.\n", len(routes.Operations))
+	default:
+		return errors.New("openapi takes print or check")
+	}
+	return nil
+}
+
+func Migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Parse(args)
+	if err := _OpenDB(); err != nil {
+		return err
+	}
+	action := "up"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	var ran []dbhelper.Migration
+	var err error
+	switch action {
+	case "status":
+		return _PrintMigrationStatus()
+	case "up":
+		ran, err = dbhelper.MigrateUp()
+	case "down":
+		ran, err = dbhelper.MigrateDown()
+	case "to":
+		if fs.NArg() < 2 {
+			return errors.New("migrate to needs a version")
+		}
+		version, convErr := strconv.Atoi(fs.Arg(1))
+		if convErr != nil {
+			return errors.New(fmt.Sprintf("Invalid version %q.", fs.Arg(1)))
+		}
+		ran, err = dbhelper.MigrateTo(version)
+	default:
+		return errors.New("migrate takes status, up, down or to VERSION")
+	}
+	for _, migration := range ran {
+		fmt.Printf("Ran migration %d %s.\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ran) == 0 {
+		fmt.Println("Nothing to migrate.")
+	}
+	return nil
+}
+
+func _PrintMigrationStatus() error {
+	statuses, err := dbhelper.GetMigrationStatus()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.Applied {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return w.Flush()
+}
+
+func GenerateKey(args []string) error {
+	fs := flag.NewFlagSet("generate-key", flag.ExitOnError)
+	keyType := fs.String("type", "jwt", "jwt for a JWT secret, audit for an audit log key pair")
+	fs.Parse(args)
+	switch *keyType {
+	case "jwt":
+		secret, err := utils.GenerateJWTSecret()
+		if err != nil {
+			return err
+		}
+		fmt.Println(secret)
+	case "audit":
+		// the verifying side only needs the public key
+		signingKey, publicKey, err := utils.GenerateSigningKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s=%s\n%s=%s\n", utils.AUDIT_SIGNING_KEY, signingKey, utils.AUDIT_PUBLIC_KEY, publicKey)
+	default:
+		return errors.New("-type must be jwt or audit")
+	}
+	return nil
+}
+
+func RotateKeys(args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	tokenType := fs.String("type", "all", "which secret to rotate: access, refresh or all")
+	fs.Parse(args)
+	var keys [][2]string
+	switch *tokenType {
+	case utils.ACCESS_TYPE:
+		keys = [][2]string{{utils.JWT_SECRET_KEY_ACCESS, utils.JWT_SECRET_KEY_ACCESS_OLD}}
+	case utils.REFRESH_TYPE:
+		keys = [][2]string{{utils.JWT_SECRET_KEY_REFRESH, utils.JWT_SECRET_KEY_REFRESH_OLD}}
+	case "all":
+		keys = [][2]string{
+			{utils.JWT_SECRET_KEY_ACCESS, utils.JWT_SECRET_KEY_ACCESS_OLD},
+			{utils.JWT_SECRET_KEY_REFRESH, utils.JWT_SECRET_KEY_REFRESH_OLD},
+		}
+	default:
+		return errors.New("-type must be access, refresh or all")
+	}
+	envMap, err := godotenv.Read(envFile)
+	if err != nil {
+		return err
+	}
+	var updates [][2]string
+	for _, key := range keys {
+		current, old := key[0], key[1]
+		secret, err := utils.GenerateJWTSecret()
+		if err != nil {
+			return err
+		}
+		// tokens signed with the previous secret stay valid until they expire
+		oldSecret := envMap[current]
+		if len(oldSecret) == 0 {
+			oldSecret = secret
+		}
+		updates = append(updates, [2]string{old, oldSecret}, [2]string{current, secret})
+	}
+	err = _SetEnvVariables(envFile, updates)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rotated %s secret(s) in %s. Restart the server to pick them up.\n", *tokenType, envFile)
+	return nil
+}
+
+// Rewrites only the lines assigning the given variables, appending the ones the
+// file doesn't have, so the operator's comments, order and quoting stay as they
+// were.
+func _SetEnvVariables(path string, variables [][2]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for _, variable := range variables {
+		name, value := variable[0], variable[1]
+		found := false
+		for i, line := range lines {
+			assignment := strings.TrimSpace(line)
+			prefix := ""
+			if strings.HasPrefix(assignment, "export ") {
+				prefix = "export "
+				assignment = strings.TrimSpace(strings.TrimPrefix(assignment, "export "))
+			}
+			current, _, isAssignment := strings.Cut(assignment, "=")
+			if isAssignment && strings.TrimSpace(current) == name {
+				lines[i] = prefix + name + "=" + value
+				found = true
+			}
+		}
+		if !found {
+			lines = append(lines, name + "=" + value)
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n") + "\n"), info.Mode().Perm())
+}
+
+func _LoadConfig() (*config.Config, error) {
+	err := godotenv.Load(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return config.Load(configFile)
+}
+
+func _OpenDB() error {
+	cfg, err := _LoadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Database.Driver == config.DATABASE_DRIVER_MEMORY {
+		return errors.New("The server keeps its data in memory, there is no database to manage.")
+	}
+	dbhelper.SetAuditConfig(cfg.Audit)
+	return dbhelper.OpenDB(cfg.Database)
+}
+
+func _PasswordOrPrompt(password string) (string, error) {
+	if len(password) > 0 {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
\ No newline at end of file
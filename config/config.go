@@ -0,0 +1,666 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"crypto/ed25519"
+	"encoding/base64"
+	"bytes"
+	"strconv"
+	"strings"
+	"errors"
+	"time"
+	"fmt"
+)
+
+const DATABASE_DRIVER_MYSQL = "mysql"
+const DATABASE_DRIVER_POSTGRES = "postgres"
+const DATABASE_DRIVER_SQLITE = "sqlite"
+// keeps everything in process memory, for demos
+const DATABASE_DRIVER_MEMORY = "memory"
+
+const LOG_FORMAT_JSON = "json"
+const LOG_FORMAT_TEXT = "text"
+
+const TLS_VERSION_1_2 = "1.2"
+const TLS_VERSION_1_3 = "1.3"
+
+const CLIENT_AUTH_NONE = "none"
+const CLIENT_AUTH_OPTIONAL = "optional"
+const CLIENT_AUTH_REQUIRE = "require"
+
+const ATTEMPT_STORE_MEMORY = "memory"
+const ATTEMPT_STORE_REDIS = "redis"
+
+const STUFFING_ACTION_NONE = "none"
+const STUFFING_ACTION_DELAY = "delay"
+const STUFFING_ACTION_CAPTCHA = "captcha"
+const STUFFING_ACTION_BLOCK = "block"
+
+const SESSION_MODE_BODY = "body"
+const SESSION_MODE_COOKIE = "cookie"
+
+const SAME_SITE_STRICT = "strict"
+const SAME_SITE_LAX = "lax"
+const SAME_SITE_NONE = "none"
+
+var CAPTCHA_PROVIDERS = []string{"", "hcaptcha", "recaptcha", "turnstile"}
+
+// Every setting can be given in the YAML file under its yaml path, as the env var
+// in its env tag, or as a flag named after its yaml path (e.g. -database.user).
+type Config struct {
+	Server ServerConfig `yaml:"server"`
+	Log LogConfig `yaml:"log"`
+	Database DatabaseConfig `yaml:"database"`
+	JWT JWTConfig `yaml:"jwt"`
+	PasswordReset PasswordResetConfig `yaml:"passwordReset"`
+	Lockout LockoutConfig `yaml:"lockout"`
+	AttemptStore AttemptStoreConfig `yaml:"attemptStore"`
+	RateLimits RateLimitConfig `yaml:"rateLimits"`
+	Stuffing StuffingConfig `yaml:"stuffing"`
+	Captcha CaptchaConfig `yaml:"captcha"`
+	Audit AuditConfig `yaml:"audit"`
+	CORS CORSConfig `yaml:"cors"`
+	Session SessionConfig `yaml:"session"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" env:"SERVER_ADDR"`
+	// plain HTTP listener that serves only /metrics, keep it on an internal
+	// interface. None when empty
+	MetricsAddr string `yaml:"metricsAddr" env:"SERVER_METRICS_ADDR"`
+	// number of reverse proxies in front of the server, see middlewares.GetClientIP
+	TrustedProxyHops int `yaml:"trustedProxyHops" env:"TRUSTED_PROXY_HOPS"`
+	// how long a client gets to send the headers, the whole request, and to read
+	// the response, and how long an idle keep-alive connection is kept
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	MaxHeaderBytes int `yaml:"maxHeaderBytes" env:"SERVER_MAX_HEADER_BYTES"`
+	// in-flight requests get this long to finish on SIGTERM or SIGINT
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	TLS ServerTLSConfig `yaml:"tls"`
+}
+
+type ServerTLSConfig struct {
+	// HTTPS is served when both are set, and the files are reloaded when they change
+	CertFile string `yaml:"certFile" env:"SERVER_TLS_CERT_FILE"`
+	KeyFile string `yaml:"keyFile" env:"SERVER_TLS_KEY_FILE"`
+	// 1.2 or 1.3
+	MinVersion string `yaml:"minVersion" env:"SERVER_TLS_MIN_VERSION"`
+	// plain HTTP listener that redirects to HTTPS (e.g. ":80"), none when empty
+	RedirectAddr string `yaml:"redirectAddr" env:"SERVER_TLS_REDIRECT_ADDR"`
+	// max-age of the Strict-Transport-Security header, 0 leaves it out
+	HSTSMaxAge time.Duration `yaml:"hstsMaxAge" env:"SERVER_HSTS_MAX_AGE"`
+	HSTSIncludeSubdomains bool `yaml:"hstsIncludeSubdomains" env:"SERVER_HSTS_INCLUDE_SUBDOMAINS"`
+	// none, optional (checked when presented) or require. /metrics on the main
+	// listener always needs a client certificate
+	ClientAuth string `yaml:"clientAuth" env:"SERVER_TLS_CLIENT_AUTH"`
+	// CA that signs the client certificates of internal callers
+	ClientCAFile string `yaml:"clientCAFile" env:"SERVER_TLS_CLIENT_CA_FILE"`
+}
+
+func (c ServerTLSConfig) Enabled() bool {
+	return len(c.CertFile) > 0 && len(c.KeyFile) > 0
+}
+
+type LogConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// json or text
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// logs go to stderr when empty
+	File string `yaml:"file" env:"LOG_FILE"`
+	// the file is rotated once it grows past MaxSizeMB, old files are deleted once
+	// there are more than MaxBackups of them or they are older than MaxAge
+	MaxSizeMB int `yaml:"maxSizeMB"`
+	MaxBackups int `yaml:"maxBackups"`
+	MaxAge time.Duration `yaml:"maxAge"`
+	Compress bool `yaml:"compress"`
+}
+
+type DatabaseConfig struct {
+	// mysql, postgres, sqlite or memory
+	Driver string `yaml:"driver" env:"DBDRIVER"`
+	User string `yaml:"user" env:"DBUSER"`
+	Password string `yaml:"password" env:"DBPASS"`
+	// the database file's path for sqlite, which ignores the connection settings below
+	Name string `yaml:"name" env:"DBNAME"`
+	Host string `yaml:"host" env:"DBHOST"`
+	// 0 uses the driver's default port
+	Port int `yaml:"port" env:"DBPORT"`
+	// connects over this unix socket instead of host and port when set, for
+	// postgres this is the directory holding the socket
+	Socket string `yaml:"socket" env:"DBSOCKET"`
+	TLS DatabaseTLSConfig `yaml:"tls"`
+	MaxOpenConns int `yaml:"maxOpenConns"`
+	MaxIdleConns int `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+	// "host[:port]" of replicas serving read-only queries, they share the primary's
+	// credentials, database name and TLS settings except serverName
+	ReadReplicas []string `yaml:"readReplicas"`
+	// tries to connect this many times at startup, doubling ConnectBackoff after each failure
+	ConnectAttempts int `yaml:"connectAttempts"`
+	ConnectBackoff time.Duration `yaml:"connectBackoff"`
+}
+
+type DatabaseTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PEM files, the system roots are used when CAFile is empty
+	CAFile string `yaml:"caFile"`
+	CertFile string `yaml:"certFile"`
+	KeyFile string `yaml:"keyFile"`
+	// name on the primary's certificate, defaults to its host. Read replicas are
+	// always checked against their own host
+	ServerName string `yaml:"serverName"`
+}
+
+// Secrets are base64 encoded. Tokens signed with the old secrets are still accepted
+// so secrets can be rotated without logging everyone out.
+type JWTConfig struct {
+	AccessSecret string `yaml:"accessSecret" env:"JWT_SECRET_KEY_ACCESS"`
+	RefreshSecret string `yaml:"refreshSecret" env:"JWT_SECRET_KEY_REFRESH"`
+	AccessSecretOld string `yaml:"accessSecretOld" env:"JWT_SECRET_KEY_ACCESS_OLD"`
+	RefreshSecretOld string `yaml:"refreshSecretOld" env:"JWT_SECRET_KEY_REFRESH_OLD"`
+	AccessTokenDuration time.Duration `yaml:"accessTokenDuration" env:"ACCESS_TOKEN_DURATION"`
+	RefreshTokenDuration time.Duration `yaml:"refreshTokenDuration" env:"REFRESH_TOKEN_DURATION"`
+}
+
+type PasswordResetConfig struct {
+	CodeDuration time.Duration `yaml:"codeDuration" env:"RESET_CODE_DURATION"`
+}
+
+type LockoutPolicyConfig struct {
+	MaxAttempts int `yaml:"maxAttempts"`
+	// attempts are forgotten after this long without one, and the first ban lasts as long
+	BanDuration time.Duration `yaml:"banDuration"`
+}
+
+type LockoutConfig struct {
+	Login LockoutPolicyConfig `yaml:"login"`
+	ResetRequest LockoutPolicyConfig `yaml:"resetRequest"`
+	ResetAttempt LockoutPolicyConfig `yaml:"resetAttempt"`
+	BanMultiplier float64 `yaml:"banMultiplier" env:"LOCKOUT_BAN_MULTIPLIER"`
+	MaxBan time.Duration `yaml:"maxBan" env:"LOCKOUT_MAX_BAN"`
+	HistoryDuration time.Duration `yaml:"historyDuration" env:"LOCKOUT_HISTORY_DURATION"`
+}
+
+type AttemptStoreConfig struct {
+	Backend string `yaml:"backend" env:"ATTEMPT_STORE"`
+	RedisAddr string `yaml:"redisAddr" env:"REDIS_ADDR"`
+	RedisPassword string `yaml:"redisPassword" env:"REDIS_PASSWORD"`
+	RedisDB int `yaml:"redisDB" env:"REDIS_DB"`
+}
+
+// Per-IP request limits of the /api/auth and /api/admin endpoints.
+type RateLimitConfig struct {
+	Login Limit `yaml:"login" env:"RATE_LIMIT_LOGIN"`
+	Signup Limit `yaml:"signup" env:"RATE_LIMIT_SIGNUP"`
+	RequestPasswordReset Limit `yaml:"requestPasswordReset" env:"RATE_LIMIT_REQUEST_PASSWORD_RESET"`
+	ResetPassword Limit `yaml:"resetPassword" env:"RATE_LIMIT_RESET_PASSWORD"`
+	RefreshJWTToken Limit `yaml:"refreshJWTToken" env:"RATE_LIMIT_REFRESH_JWT_TOKEN"`
+	Profile Limit `yaml:"profile" env:"RATE_LIMIT_PROFILE"`
+	Admin Limit `yaml:"admin" env:"RATE_LIMIT_ADMIN"`
+}
+
+// Failed logins across all emails before a credential stuffing defense kicks in,
+// and the action it takes.
+type StuffingConfig struct {
+	IPLimit Limit `yaml:"ipLimit" env:"STUFFING_IP_LIMIT"`
+	IPAction string `yaml:"ipAction" env:"STUFFING_IP_ACTION"`
+	SubnetLimit Limit `yaml:"subnetLimit" env:"STUFFING_SUBNET_LIMIT"`
+	SubnetAction string `yaml:"subnetAction" env:"STUFFING_SUBNET_ACTION"`
+	GlobalLimit Limit `yaml:"globalLimit" env:"STUFFING_GLOBAL_LIMIT"`
+	GlobalAction string `yaml:"globalAction" env:"STUFFING_GLOBAL_ACTION"`
+	Delay time.Duration `yaml:"delay" env:"STUFFING_DELAY"`
+}
+
+type CaptchaConfig struct {
+	// empty disables captchas
+	Provider string `yaml:"provider" env:"CAPTCHA_PROVIDER"`
+	Secret string `yaml:"secret" env:"CAPTCHA_SECRET"`
+	LoginAfter int `yaml:"loginAfter" env:"CAPTCHA_AFTER_NUM_LOGIN_ATTEMPTS"`
+	ResetRequestAfter int `yaml:"resetRequestAfter" env:"CAPTCHA_AFTER_NUM_PASS_RESET_CODES"`
+}
+
+type AuditConfig struct {
+	// base64 Ed25519 private key signing the audit log checkpoints, none are
+	// written without one. Only the server needs it
+	SigningKey string `yaml:"signingKey" env:"AUDIT_SIGNING_KEY"`
+	// base64 Ed25519 public key checking the signatures, derived from SigningKey
+	// when empty, so verifying doesn't need the private key
+	PublicKey string `yaml:"publicKey" env:"AUDIT_PUBLIC_KEY"`
+	// still accepted when verifying checkpoints signed before the key was replaced
+	PublicKeyOld string `yaml:"publicKeyOld" env:"AUDIT_PUBLIC_KEY_OLD"`
+	CheckpointInterval time.Duration `yaml:"checkpointInterval" env:"AUDIT_CHECKPOINT_INTERVAL"`
+}
+
+// How web clients hold refresh tokens.
+type SessionConfig struct {
+	// body returns refresh tokens in response bodies. cookie sets them as an
+	// HttpOnly cookie that only the refresh endpoint receives, and requires a CSRF
+	// token on the endpoints acting on a session.
+	Mode string `yaml:"mode" env:"SESSION_MODE"`
+	CookieName string `yaml:"cookieName"`
+	// the cookies' Domain, host-only when empty
+	CookieDomain string `yaml:"cookieDomain"`
+	// strict, lax or none (for an API on another site, which needs secure)
+	SameSite string `yaml:"sameSite"`
+	// only turn off for local development over plain HTTP
+	Secure bool `yaml:"secure"`
+	// the session's CSRF token is also sent in this cookie, which scripts can
+	// read, and must be echoed in this header
+	CSRFCookieName string `yaml:"csrfCookieName"`
+	CSRFHeaderName string `yaml:"csrfHeaderName"`
+}
+
+// Which other origins browsers let call a group of routes, see middlewares.CORS.
+type CORSPolicyConfig struct {
+	// e.g. https://shop.example.com, or https://*.example.com for any of its
+	// subdomains. Cross-origin calls are refused when empty.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	AllowedMethods []string `yaml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// response headers scripts may read
+	ExposedHeaders []string `yaml:"exposedHeaders"`
+	// lets requests carry cookies, can't be used with the "*" origin
+	AllowCredentials bool `yaml:"allowCredentials"`
+	// how long browsers cache a preflight response, 0 leaves it to the browser
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// CORS policies by route prefix, e.g. /api/auth. The server refuses to start
+// with a prefix no route is under, see routes.CreateRoutes. Only set in the
+// config file.
+type CORSConfig map[string]CORSPolicyConfig
+
+// Decodes each policy over the default of its prefix, or the defaults every new
+// prefix starts from, so the file only needs the settings it changes.
+func (c *CORSConfig) UnmarshalYAML(node *yaml.Node) error {
+	var entries map[string]yaml.Node
+	err := node.Decode(&entries)
+	if err != nil {
+		return err
+	}
+	policies := CORSConfig{}
+	for prefix, policy := range *c {
+		policies[prefix] = policy
+	}
+	for prefix, entry := range entries {
+		policy, ok := policies[prefix]
+		if !ok {
+			policy = _DefaultCORSPolicy(nil)
+		}
+		// Node.Decode doesn't refuse unknown fields like the file's decoder does
+		data, err := yaml.Marshal(&entry)
+		if err != nil {
+			return err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&policy)
+		if err != nil {
+			return errors.New(fmt.Sprintf("cors.%s: %v", prefix, err))
+		}
+		policies[prefix] = policy
+	}
+	*c = policies
+	return nil
+}
+
+// A count per duration, written as "<count>/<duration>", e.g. "5/1m".
+type Limit struct {
+	Count int
+	Per time.Duration
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr: ":5005",
+			MetricsAddr: "127.0.0.1:9091",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout: 15 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout: 2 * time.Minute,
+			MaxHeaderBytes: 64 << 10,
+			ShutdownTimeout: 30 * time.Second,
+			TLS: ServerTLSConfig{
+				MinVersion: TLS_VERSION_1_2,
+				HSTSMaxAge: 365 * 24 * time.Hour,
+				ClientAuth: CLIENT_AUTH_NONE,
+			},
+		},
+		Log: LogConfig{
+			Level: "info",
+			Format: LOG_FORMAT_JSON,
+			File: "logs.txt",
+			MaxSizeMB: 100,
+			MaxBackups: 5,
+			MaxAge: 30 * 24 * time.Hour,
+			Compress: true,
+		},
+		Database: DatabaseConfig{
+			Driver: DATABASE_DRIVER_MYSQL,
+			Host: "127.0.0.1",
+			MaxOpenConns: 20,
+			MaxIdleConns: 10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectAttempts: 10,
+			ConnectBackoff: time.Second,
+		},
+		JWT: JWTConfig{
+			AccessTokenDuration: 15 * time.Minute,
+			RefreshTokenDuration: 7 * 24 * time.Hour,
+		},
+		PasswordReset: PasswordResetConfig{
+			CodeDuration: 20 * time.Minute,
+		},
+		Lockout: LockoutConfig{
+			Login: LockoutPolicyConfig{MaxAttempts: 5, BanDuration: 10 * time.Minute},
+			ResetRequest: LockoutPolicyConfig{MaxAttempts: 10, BanDuration: 10 * time.Minute},
+			ResetAttempt: LockoutPolicyConfig{MaxAttempts: 10, BanDuration: 10 * time.Minute},
+			BanMultiplier: 2,
+			MaxBan: 24 * time.Hour,
+			HistoryDuration: 24 * time.Hour,
+		},
+		AttemptStore: AttemptStoreConfig{
+			Backend: ATTEMPT_STORE_MEMORY,
+		},
+		RateLimits: RateLimitConfig{
+			Login: Limit{10, time.Minute},
+			Signup: Limit{5, time.Minute},
+			RequestPasswordReset: Limit{5, time.Minute},
+			ResetPassword: Limit{10, time.Minute},
+			RefreshJWTToken: Limit{30, time.Minute},
+			Profile: Limit{60, time.Minute},
+			Admin: Limit{60, time.Minute},
+		},
+		Stuffing: StuffingConfig{
+			IPLimit: Limit{20, 10 * time.Minute},
+			IPAction: STUFFING_ACTION_BLOCK,
+			SubnetLimit: Limit{100, 10 * time.Minute},
+			SubnetAction: STUFFING_ACTION_DELAY,
+			GlobalLimit: Limit{1000, time.Minute},
+			GlobalAction: STUFFING_ACTION_DELAY,
+			Delay: 2 * time.Second,
+		},
+		Captcha: CaptchaConfig{
+			LoginAfter: 3,
+			ResetRequestAfter: 3,
+		},
+		Audit: AuditConfig{
+			CheckpointInterval: time.Hour,
+		},
+		Session: SessionConfig{
+			Mode: SESSION_MODE_BODY,
+			CookieName: "refresh_token",
+			SameSite: SAME_SITE_STRICT,
+			Secure: true,
+			CSRFCookieName: "csrf_token",
+			CSRFHeaderName: "X-CSRF-Token",
+		},
+		CORS: CORSConfig{
+			"/api/auth": _DefaultCORSPolicy([]string{"GET", "POST", "PATCH", "DELETE"}),
+			"/api/admin": _DefaultCORSPolicy([]string{"GET"}),
+		},
+	}
+}
+
+func _DefaultCORSPolicy(methods []string) CORSPolicyConfig {
+	return CORSPolicyConfig{
+		AllowedMethods: methods,
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID"},
+		ExposedHeaders: []string{"Retry-After", "X-Request-ID"},
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// Returns every problem with the config at once, so startup fails with a full list.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(len(c.Server.Addr) > 0, "server.addr is required")
+	check(c.Server.MetricsAddr != c.Server.Addr, "server.metricsAddr must differ from server.addr")
+	check(c.Server.TrustedProxyHops >= 0, "server.trustedProxyHops can't be negative")
+	timeouts := map[string]time.Duration{
+		"server.readHeaderTimeout": c.Server.ReadHeaderTimeout,
+		"server.readTimeout": c.Server.ReadTimeout,
+		"server.writeTimeout": c.Server.WriteTimeout,
+		"server.idleTimeout": c.Server.IdleTimeout,
+		"server.shutdownTimeout": c.Server.ShutdownTimeout,
+	}
+	for name, timeout := range timeouts {
+		check(timeout > 0, "%s must be positive", name)
+	}
+	check(c.Server.MaxHeaderBytes > 0, "server.maxHeaderBytes must be positive")
+	serverTLS := c.Server.TLS
+	check(
+		(len(serverTLS.CertFile) > 0) == (len(serverTLS.KeyFile) > 0),
+		"server.tls.certFile and server.tls.keyFile must be set together",
+	)
+	check(
+		serverTLS.MinVersion == TLS_VERSION_1_2 || serverTLS.MinVersion == TLS_VERSION_1_3,
+		"server.tls.minVersion must be %s or %s", TLS_VERSION_1_2, TLS_VERSION_1_3,
+	)
+	check(serverTLS.HSTSMaxAge >= 0, "server.tls.hstsMaxAge can't be negative")
+	check(
+		_Contains([]string{CLIENT_AUTH_NONE, CLIENT_AUTH_OPTIONAL, CLIENT_AUTH_REQUIRE}, serverTLS.ClientAuth),
+		"server.tls.clientAuth must be %s, %s or %s", CLIENT_AUTH_NONE, CLIENT_AUTH_OPTIONAL, CLIENT_AUTH_REQUIRE,
+	)
+	if serverTLS.ClientAuth != CLIENT_AUTH_NONE {
+		check(len(serverTLS.ClientCAFile) > 0, "server.tls.clientCAFile is required for client certificates")
+	}
+	if !serverTLS.Enabled() {
+		check(len(serverTLS.RedirectAddr) == 0, "server.tls.redirectAddr needs server.tls.certFile")
+		check(serverTLS.ClientAuth == CLIENT_AUTH_NONE, "server.tls.clientAuth needs server.tls.certFile")
+	}
+	check(
+		_Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)),
+		"log.level must be debug, info, warn or error",
+	)
+	check(
+		c.Log.Format == LOG_FORMAT_JSON || c.Log.Format == LOG_FORMAT_TEXT,
+		"log.format must be %s or %s", LOG_FORMAT_JSON, LOG_FORMAT_TEXT,
+	)
+	check(c.Log.MaxSizeMB > 0, "log.maxSizeMB must be positive")
+	check(c.Log.MaxBackups >= 0, "log.maxBackups can't be negative")
+	check(c.Log.MaxAge >= 0, "log.maxAge can't be negative")
+	databaseDrivers := []string{DATABASE_DRIVER_MYSQL, DATABASE_DRIVER_POSTGRES, DATABASE_DRIVER_SQLITE, DATABASE_DRIVER_MEMORY}
+	check(
+		_Contains(databaseDrivers, c.Database.Driver),
+		"database.driver must be %s", strings.Join(databaseDrivers, ", "),
+	)
+	if c.Database.Driver == DATABASE_DRIVER_MEMORY {
+		check(len(c.Database.ReadReplicas) == 0, "database.readReplicas isn't supported by the memory driver")
+	} else if c.Database.Driver == DATABASE_DRIVER_SQLITE {
+		check(len(c.Database.Name) > 0, "database.name is required")
+		check(len(c.Database.ReadReplicas) == 0, "database.readReplicas isn't supported by sqlite")
+	} else {
+		check(len(c.Database.Name) > 0, "database.name is required")
+		check(len(c.Database.User) > 0, "database.user is required")
+		check(len(c.Database.Socket) > 0 || len(c.Database.Host) > 0, "database.host or database.socket is required")
+	}
+	check(c.Database.Port >= 0 && c.Database.Port < 65536, "database.port must be between 0 and 65535")
+	check(
+		(len(c.Database.TLS.CertFile) > 0) == (len(c.Database.TLS.KeyFile) > 0),
+		"database.tls.certFile and database.tls.keyFile must be set together",
+	)
+	check(c.Database.MaxOpenConns >= 0, "database.maxOpenConns can't be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.maxIdleConns can't be negative")
+	check(c.Database.ConnMaxLifetime >= 0, "database.connMaxLifetime can't be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.connMaxIdleTime can't be negative")
+	check(c.Database.ConnectAttempts > 0, "database.connectAttempts must be positive")
+	check(c.Database.ConnectBackoff >= 0, "database.connectBackoff can't be negative")
+	secrets := map[string]string{
+		"jwt.accessSecret": c.JWT.AccessSecret,
+		"jwt.refreshSecret": c.JWT.RefreshSecret,
+		"jwt.accessSecretOld": c.JWT.AccessSecretOld,
+		"jwt.refreshSecretOld": c.JWT.RefreshSecretOld,
+	}
+	for name, secret := range secrets {
+		_, err := base64.StdEncoding.DecodeString(secret)
+		check(err == nil, "%s is not valid base64", name)
+	}
+	auditKeys := map[string]struct{
+		value string
+		sizes []int
+	}{
+		// a 32 byte seed or the 64 byte private key holding it
+		"audit.signingKey": {c.Audit.SigningKey, []int{ed25519.SeedSize, ed25519.PrivateKeySize}},
+		"audit.publicKey": {c.Audit.PublicKey, []int{ed25519.PublicKeySize}},
+		"audit.publicKeyOld": {c.Audit.PublicKeyOld, []int{ed25519.PublicKeySize}},
+	}
+	for name, key := range auditKeys {
+		if len(key.value) == 0 {
+			continue
+		}
+		bytes, err := base64.StdEncoding.DecodeString(key.value)
+		check(err == nil && _ContainsInt(key.sizes, len(bytes)), "%s is not a base64 Ed25519 key, make one with authctl generate-key -type audit", name)
+	}
+	check(len(c.JWT.AccessSecret) > 0, "jwt.accessSecret is required")
+	check(len(c.JWT.RefreshSecret) > 0, "jwt.refreshSecret is required")
+	check(c.JWT.AccessTokenDuration > 0, "jwt.accessTokenDuration must be positive")
+	check(c.JWT.RefreshTokenDuration > 0, "jwt.refreshTokenDuration must be positive")
+	check(c.PasswordReset.CodeDuration > 0, "passwordReset.codeDuration must be positive")
+	policies := map[string]LockoutPolicyConfig{
+		"lockout.login": c.Lockout.Login,
+		"lockout.resetRequest": c.Lockout.ResetRequest,
+		"lockout.resetAttempt": c.Lockout.ResetAttempt,
+	}
+	for name, policy := range policies {
+		check(policy.MaxAttempts > 0, "%s.maxAttempts must be positive", name)
+		check(policy.BanDuration > 0, "%s.banDuration must be positive", name)
+	}
+	check(c.Lockout.BanMultiplier >= 1, "lockout.banMultiplier must be at least 1")
+	check(c.Lockout.MaxBan > 0, "lockout.maxBan must be positive")
+	check(c.Lockout.HistoryDuration > 0, "lockout.historyDuration must be positive")
+	check(
+		c.AttemptStore.Backend == ATTEMPT_STORE_MEMORY || c.AttemptStore.Backend == ATTEMPT_STORE_REDIS,
+		"attemptStore.backend must be %s or %s", ATTEMPT_STORE_MEMORY, ATTEMPT_STORE_REDIS,
+	)
+	if c.AttemptStore.Backend == ATTEMPT_STORE_REDIS {
+		check(len(c.AttemptStore.RedisAddr) > 0, "attemptStore.redisAddr is required for the redis backend")
+	}
+	actions := map[string]string{
+		"stuffing.ipAction": c.Stuffing.IPAction,
+		"stuffing.subnetAction": c.Stuffing.SubnetAction,
+		"stuffing.globalAction": c.Stuffing.GlobalAction,
+	}
+	for name, action := range actions {
+		check(_IsStuffingAction(action), "%s must be none, delay, captcha or block", name)
+		check(
+			action != STUFFING_ACTION_CAPTCHA || len(c.Captcha.Provider) > 0,
+			"%s is captcha but captcha.provider is not set", name,
+		)
+	}
+	check(c.Stuffing.Delay >= 0, "stuffing.delay can't be negative")
+	check(_Contains(CAPTCHA_PROVIDERS, c.Captcha.Provider), "captcha.provider must be hcaptcha, recaptcha or turnstile")
+	check(len(c.Captcha.Provider) == 0 || len(c.Captcha.Secret) > 0, "captcha.secret is required")
+	check(c.Audit.CheckpointInterval > 0, "audit.checkpointInterval must be positive")
+	check(
+		c.Session.Mode == SESSION_MODE_BODY || c.Session.Mode == SESSION_MODE_COOKIE,
+		"session.mode must be %s or %s", SESSION_MODE_BODY, SESSION_MODE_COOKIE,
+	)
+	if c.Session.Mode == SESSION_MODE_COOKIE {
+		check(len(c.Session.CookieName) > 0, "session.cookieName is required")
+		check(len(c.Session.CSRFCookieName) > 0, "session.csrfCookieName is required")
+		check(len(c.Session.CSRFHeaderName) > 0, "session.csrfHeaderName is required")
+	}
+	check(
+		_Contains([]string{SAME_SITE_STRICT, SAME_SITE_LAX, SAME_SITE_NONE}, c.Session.SameSite),
+		"session.sameSite must be %s, %s or %s", SAME_SITE_STRICT, SAME_SITE_LAX, SAME_SITE_NONE,
+	)
+	check(c.Session.SameSite != SAME_SITE_NONE || c.Session.Secure, "session.sameSite none needs session.secure")
+	for prefix, policy := range c.CORS {
+		name := "cors." + prefix
+		check(
+			strings.HasPrefix(prefix, "/") && (prefix == "/" || !strings.HasSuffix(prefix, "/")),
+			"%s must be a path starting with / and not ending with one", name,
+		)
+		for _, origin := range policy.AllowedOrigins {
+			check(_IsOriginPattern(origin), "%s.allowedOrigins has an invalid origin %q", name, origin)
+		}
+		check(
+			!policy.AllowCredentials || !_Contains(policy.AllowedOrigins, "*"),
+			"%s.allowCredentials can't be used with the \"*\" origin", name,
+		)
+		check(len(policy.AllowedOrigins) == 0 || len(policy.AllowedMethods) > 0, "%s.allowedMethods is required", name)
+		check(policy.MaxAge >= 0, "%s.maxAge can't be negative", name)
+	}
+	if len(problems) > 0 {
+		return errors.New("Invalid config: " + strings.Join(problems, "; ") + ".")
+	}
+	return nil
+}
+
+func (l *Limit) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), "/")
+	if len(parts) != 2 {
+		return errors.New(fmt.Sprintf("Invalid limit %q.", text))
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return errors.New(fmt.Sprintf("Invalid count in limit %q.", text))
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return errors.New(fmt.Sprintf("Invalid duration in limit %q.", text))
+	}
+	l.Count, l.Per = count, per
+	return nil
+}
+
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Count, l.Per)
+}
+
+// "*", or scheme://host[:port] where the host may start with "*." to match any
+// subdomain.
+func _IsOriginPattern(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || len(scheme) == 0 || len(host) == 0 || strings.ContainsAny(host, "/?#") {
+		return false
+	}
+	return !strings.Contains(strings.TrimPrefix(host, "*."), "*")
+}
+
+func _IsStuffingAction(action string) bool {
+	return _Contains([]string{
+		STUFFING_ACTION_NONE,
+		STUFFING_ACTION_DELAY,
+		STUFFING_ACTION_CAPTCHA,
+		STUFFING_ACTION_BLOCK,
+	}, action)
+}
+
+func _Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func _ContainsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"encoding"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"errors"
+	"flag"
+	"time"
+	"fmt"
+	"os"
+)
+
+// env var naming the YAML config file when -config isn't given
+const CONFIG_FILE = "CONFIG_FILE"
+
+type setting struct {
+	field reflect.Value
+	path string
+	env string
+}
+
+// Builds the config from the defaults, then the YAML file at path (skipped when
+// empty), then env vars, and validates it.
+func Load(path string) (*Config, error) {
+	config := Default()
+	err := _LoadFile(&config, path)
+	if err != nil {
+		return nil, err
+	}
+	err = _LoadEnv(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, config.Validate()
+}
+
+// Like Load, but the file is named by -config (or $CONFIG_FILE) and every setting
+// can also be passed as a flag named after its yaml path, which wins over the
+// file and env vars.
+func LoadWithFlags(name string, args []string) (*Config, error) {
+	config := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(CONFIG_FILE), "YAML config file")
+	var flagValues []func() error
+	for _, s := range _Settings(&config) {
+		s := s
+		fs.Func(s.path, fmt.Sprintf("overrides $%s", s.env), func(value string) error {
+			// applied after the file and env vars are loaded
+			flagValues = append(flagValues, func() error {
+				return _SetField(s.field, value)
+			})
+			return nil
+		})
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	err = _LoadFile(&config, *path)
+	if err != nil {
+		return nil, err
+	}
+	err = _LoadEnv(&config)
+	if err != nil {
+		return nil, err
+	}
+	for _, apply := range flagValues {
+		err = apply()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &config, config.Validate()
+}
+
+func _LoadFile(config *Config, path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Couldn't read config file %s: %v", path, err))
+	}
+	return nil
+}
+
+func _LoadEnv(config *Config) error {
+	for _, s := range _Settings(config) {
+		value, ok := os.LookupEnv(s.env)
+		if !ok || len(value) == 0 {
+			continue
+		}
+		err := _SetField(s.field, value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid $%s: %v", s.env, err))
+		}
+	}
+	return nil
+}
+
+// Lists every leaf setting of config. A setting's env var comes from its env tag,
+// or else is its yaml path in upper snake case (lockout.login.maxAttempts is
+// LOCKOUT_LOGIN_MAX_ATTEMPTS).
+func _Settings(config *Config) []setting {
+	var settings []setting
+	var walk func(value reflect.Value, path []string)
+	walk = func(value reflect.Value, path []string) {
+		valueType := value.Type()
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			fieldPath := append(append([]string{}, path...), field.Tag.Get("yaml"))
+			if field.Type.Kind() == reflect.Map {
+				// keyed by the file, like the CORS policies, so only set there
+				continue
+			}
+			if field.Type.Kind() == reflect.Struct && !_IsLeaf(value.Field(i)) {
+				walk(value.Field(i), fieldPath)
+				continue
+			}
+			env := field.Tag.Get("env")
+			if len(env) == 0 {
+				env = _EnvName(fieldPath)
+			}
+			settings = append(settings, setting{
+				field: value.Field(i),
+				path: strings.Join(fieldPath, "."),
+				env: env,
+			})
+		}
+	}
+	walk(reflect.ValueOf(config).Elem(), nil)
+	return settings
+}
+
+func _IsLeaf(field reflect.Value) bool {
+	_, ok := field.Addr().Interface().(encoding.TextUnmarshaler)
+	return ok
+}
+
+func _SetField(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(number))
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(number)
+	case reflect.Bool:
+		boolean, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(boolean)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New(fmt.Sprintf("Unsupported setting type %s.", field.Type()))
+		}
+		// lists are comma separated in env vars and flags
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return errors.New(fmt.Sprintf("Unsupported setting type %s.", field.Type()))
+	}
+	return nil
+}
+
+func _EnvName(path []string) string {
+	var name strings.Builder
+	for i, part := range path {
+		if i > 0 {
+			name.WriteByte('_')
+		}
+		for j, r := range part {
+			if j > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(part[j-1])) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
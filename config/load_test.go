@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func _WriteConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// Loads the file over the defaults without env vars or validation.
+func _LoadTestFile(t *testing.T, path string) (Config, error) {
+	cfg := Default()
+	err := _LoadFile(&cfg, path)
+	return cfg, err
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	_, err := _LoadTestFile(t, filepath.Join("..", "config.example.yaml"))
+	if err != nil {
+		t.Fatalf("_LoadFile: %v", err)
+	}
+}
+
+func TestLoadCORSKeepsDefaults(t *testing.T) {
+	cfg, err := _LoadTestFile(t, _WriteConfigFile(t, `
+cors:
+  /api/auth:
+    allowedOrigins: [https://shop.example.com]
+  /api/auth/me:
+    allowedOrigins: [https://account.example.com]
+    allowedMethods: [GET]
+`))
+	if err != nil {
+		t.Fatalf("_LoadFile: %v", err)
+	}
+	auth := cfg.CORS["/api/auth"]
+	if len(auth.AllowedOrigins) != 1 || len(auth.AllowedMethods) != 4 {
+		t.Errorf("/api/auth policy is %+v, want the origin set over the default methods", auth)
+	}
+	if _, ok := cfg.CORS["/api/admin"]; !ok {
+		t.Errorf("the /api/admin default policy is gone")
+	}
+	me := cfg.CORS["/api/auth/me"]
+	if len(me.AllowedHeaders) == 0 || me.MaxAge == 0 {
+		t.Errorf("/api/auth/me policy is %+v, want the default headers and maxAge", me)
+	}
+}
+
+func TestLoadCORSRefusesBadPolicies(t *testing.T) {
+	configs := map[string]string{
+		"an unknown field": `
+cors:
+  /api/auth:
+    allowedOrigin: [https://shop.example.com]
+`,
+		"a prefix that isn't a path": `
+cors:
+  api/auth:
+    allowedOrigins: [https://shop.example.com]
+`,
+	}
+	for name, contents := range configs {
+		cfg, err := _LoadTestFile(t, _WriteConfigFile(t, contents))
+		if err == nil {
+			err = cfg.Validate()
+		}
+		if err == nil || !strings.Contains(err.Error(), "cors") {
+			t.Errorf("loading %s returned %v, want a cors error", name, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/storage"
+	"github.com/shoppingapp/apiv1/utils"
+	"errors"
+	"fmt"
+)
+
+// These helpers back the operator CLI (cmd/authctl). They skip the rate limits
+// applied to the public endpoints since they are only reachable with DB access.
+// Their audit events have no IP and authctl as the user agent.
+
+var cliClient = ClientInfo{UserAgent: "authctl"}
+
+const ADMIN_GRANTED = "granted"
+const ADMIN_REVOKED = "revoked"
+
+func AdminCreateUser(email, displayName, passwordHash string) error {
+	tx, err := Store.Begin()
+	if err != nil {
+		return _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
+	}
+	defer tx.Rollback()
+	user, err := _CreateUser(tx, email, displayName, passwordHash)
+	if err != nil {
+		return err
+	}
+	err = _RecordAuthEvent(tx, models.AUTH_EVENT_SIGNUP, user, email, cliClient, "")
+	if err != nil {
+		return _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
+	}
+	return tx.Commit()
+}
+
+func AdminSetPassword(email, passwordHash string) error {
+	tx, err := Store.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	user, err := _LockUser(tx, email)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = passwordHash
+	err = tx.UpdateUser(&user)
+	if err != nil {
+		return err
+	}
+	_, err = tx.DeleteSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	err = tx.DeleteResetCodes(user.ID)
+	if err != nil {
+		return err
+	}
+	err = _RecordAuthEvent(tx, models.AUTH_EVENT_RESET_COMPLETED, user, email, cliClient, "")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func AdminUnlockAccount(email string) error {
+	for _, policy := range []LockoutPolicy{LoginLockout, ResetRequestLockout, ResetAttemptLockout} {
+		err := policy.Unlock(email)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func AdminRevokeSessions(email string) (int64, error) {
+	tx, err := Store.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	user, err := _LockUser(tx, email)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := tx.DeleteSessions(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	err = _RecordAuthEvent(tx, models.AUTH_EVENT_SESSION_REVOKED, user, email, cliClient, "")
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+func AdminSetIsAdmin(email string, isAdmin bool) error {
+	tx, err := Store.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	user, err := _LockUser(tx, email)
+	if err != nil {
+		return err
+	}
+	user.IsAdmin = isAdmin
+	err = tx.UpdateUser(&user)
+	if err != nil {
+		return err
+	}
+	detail := ADMIN_GRANTED
+	if !isAdmin {
+		detail = ADMIN_REVOKED
+	}
+	err = _RecordAuthEvent(tx, models.AUTH_EVENT_ADMIN_CHANGED, user, email, cliClient, detail)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func _LockUser(tx storage.Tx, email string) (models.User, error) {
+	user, userExists, err := tx.LockUserByEmail(email)
+	if err != nil {
+		return user, err
+	}
+	if !userExists {
+		return user, errors.New(fmt.Sprintf("No user found with email %s.", email))
+	}
+	return user, nil
+}
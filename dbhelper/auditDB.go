@@ -0,0 +1,330 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/metrics"
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/storage"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"context"
+	"errors"
+	"time"
+	"fmt"
+)
+
+// Who made a request, recorded with each audit event.
+type ClientInfo struct {
+	IP string
+	UserAgent string
+}
+
+// What VerifyAuditLog checked before it stopped. LatestCheckpoint is the Number of
+// the last checkpoint found, worth keeping outside the database to pass as
+// minCheckpoint next time, and Unsigned counts the events after it.
+type AuditVerification struct {
+	Events int
+	Checkpoints int
+	LatestCheckpoint uint
+	Unsigned int
+}
+
+var auditConfig config.AuditConfig
+
+// The Number of the last checkpoint this process wrote. The database must never
+// go back below it.
+var lastCheckpointNumber uint
+
+func SetAuditConfig(cfg config.AuditConfig) {
+	auditConfig = cfg
+}
+
+func QueryAuthEvents(filter storage.EventFilter) ([]models.AuthEvent, error) {
+	defer metrics.ObserveDB("QueryAuthEvents", time.Now())
+	return Store.QueryEvents(filter)
+}
+
+// Signs the chain head unless the latest checkpoint already covers it, returning
+// false when there was nothing new to sign.
+func CreateAuditCheckpoint() (models.AuditCheckpoint, bool, error) {
+	key, err := _AuditSigningKey()
+	if err != nil {
+		return models.AuditCheckpoint{}, false, err
+	}
+	seq, hash, err := Store.GetChainHead()
+	if err != nil {
+		return models.AuditCheckpoint{}, false, err
+	}
+	latest, found, err := Store.GetLatestCheckpoint()
+	if err != nil {
+		return models.AuditCheckpoint{}, false, err
+	}
+	if latest.Number < lastCheckpointNumber {
+		return models.AuditCheckpoint{}, false, errors.New(fmt.Sprintf(
+			"This server wrote checkpoint %d but the latest one is %d, checkpoints were deleted.",
+			lastCheckpointNumber,
+			latest.Number,
+		))
+	}
+	if seq == 0 || (found && latest.Seq == seq) {
+		return latest, false, nil
+	}
+	checkpoint := models.AuditCheckpoint{
+		// stored to the millisecond, see storage.HashEvent
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+		Number: latest.Number + 1,
+		Seq: seq,
+		Hash: hash,
+	}
+	if found {
+		checkpoint.PrevHash = _CheckpointHash(latest)
+	}
+	checkpoint.Signature = _SignCheckpoint(key, checkpoint)
+	err = Store.CreateCheckpoint(&checkpoint)
+	if err != nil {
+		return checkpoint, false, err
+	}
+	lastCheckpointNumber = checkpoint.Number
+	return checkpoint, true, nil
+}
+
+// Creates a checkpoint every audit.checkpointInterval until ctx is done.
+func RunAuditCheckpoints(ctx context.Context) {
+	if len(auditConfig.SigningKey) == 0 {
+		slog.Warn("audit.signingKey is not set, the audit log won't be checkpointed")
+		return
+	}
+	ticker := time.NewTicker(auditConfig.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkpoint, created, err := CreateAuditCheckpoint()
+			if err != nil {
+				slog.Error("Couldn't checkpoint the audit log", "err", err)
+			} else if created {
+				slog.Info("Checkpointed the audit log", "checkpoint", checkpoint.Number, "seq", checkpoint.Seq)
+			}
+		}
+	}
+}
+
+// Walks the hash chain from its first event, checking each event's hash, every
+// checkpoint's signature and link to the one before, and that each checkpoint
+// matches the event it covers. Deleting the newest checkpoints together with the
+// events after them leaves an intact but shorter log, so pass the Number of the
+// latest checkpoint known from elsewhere (e.g. the server log) as minCheckpoint,
+// or 0. The error describes the first broken link.
+func VerifyAuditLog(minCheckpoint uint) (AuditVerification, error) {
+	const BATCH_SIZE = 1000
+	var result AuditVerification
+	checkpoints, err := Store.GetCheckpoints()
+	if err != nil {
+		return result, err
+	}
+	keys, err := _AuditPublicKeys()
+	if err != nil {
+		return result, err
+	}
+	if len(checkpoints) > 0 && len(keys) == 0 {
+		return result, errors.New("audit.publicKey is not set, checkpoints can't be verified.")
+	}
+	var prevHash string
+	var prevSeq uint
+	for i, checkpoint := range checkpoints {
+		if checkpoint.Number != uint(i+1) {
+			return result, errors.New(fmt.Sprintf("Checkpoint %d is missing, the checkpoints go from %d to %d.", i+1, i, checkpoint.Number))
+		}
+		if !_CheckpointSignedBy(keys, checkpoint) {
+			return result, errors.New(fmt.Sprintf("Checkpoint %d at event %d has an invalid signature.", checkpoint.Number, checkpoint.Seq))
+		}
+		if checkpoint.PrevHash != prevHash {
+			return result, errors.New(fmt.Sprintf("Checkpoint %d doesn't match checkpoint %d, it was replaced.", checkpoint.Number, i))
+		}
+		if checkpoint.Seq < prevSeq {
+			return result, errors.New(fmt.Sprintf("Checkpoint %d covers an earlier event than checkpoint %d.", checkpoint.Number, i))
+		}
+		prevHash, prevSeq = _CheckpointHash(checkpoint), checkpoint.Seq
+	}
+	result.LatestCheckpoint = uint(len(checkpoints))
+	if result.LatestCheckpoint < minCheckpoint {
+		return result, errors.New(fmt.Sprintf(
+			"The latest checkpoint is %d but checkpoint %d was written, checkpoints were deleted from the end.",
+			result.LatestCheckpoint,
+			minCheckpoint,
+		))
+	}
+	var seq uint
+	var hash string
+	for {
+		events, err := Store.GetEventsAfter(seq, BATCH_SIZE)
+		if err != nil {
+			return result, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, event := range events {
+			if event.Seq != seq+1 {
+				return result, errors.New(fmt.Sprintf("Event %d is missing, the chain goes from event %d to %d.", seq+1, seq, event.Seq))
+			}
+			if storage.HashEvent(hash, event) != event.Hash {
+				return result, errors.New(fmt.Sprintf(
+					"Event %d (id %d) doesn't match its hash, it was changed or the event before it was rehashed.",
+					event.Seq,
+					event.ID,
+				))
+			}
+			seq, hash = event.Seq, event.Hash
+			result.Events++
+			for len(checkpoints) > 0 && checkpoints[0].Seq == seq {
+				if checkpoints[0].Hash != hash {
+					return result, errors.New(fmt.Sprintf(
+						"Checkpoint %d doesn't match event %d, the events up to it were rewritten.",
+						checkpoints[0].Number,
+						seq,
+					))
+				}
+				checkpoints = checkpoints[1:]
+				result.Checkpoints++
+			}
+		}
+	}
+	if len(checkpoints) > 0 {
+		return result, errors.New(fmt.Sprintf(
+			"Checkpoint %d covers event %d but the log ends at event %d, events were deleted from its end.",
+			checkpoints[0].Number,
+			checkpoints[0].Seq,
+			seq,
+		))
+	}
+	headSeq, headHash, err := Store.GetChainHead()
+	if err != nil {
+		return result, err
+	}
+	if headSeq != seq || headHash != hash {
+		return result, errors.New(fmt.Sprintf("The chain head is at event %d but the log ends at event %d.", headSeq, seq))
+	}
+	result.Unsigned = int(seq - prevSeq)
+	return result, nil
+}
+
+// Records an event in tx so it's only kept if the change it describes is. user is
+// the zero User when email doesn't belong to an account. This locks the audit
+// chain until tx ends (see storage.GormStore), so call it after the slow work.
+func _RecordAuthEvent(tx storage.Tx, eventType string, user models.User, email string, client ClientInfo, detail string) error {
+	event := models.AuthEvent{
+		Type: eventType,
+		Email: email,
+		IP: client.IP,
+		UserAgent: _Truncate(client.UserAgent, 512),
+		Detail: detail,
+	}
+	if user.ID != 0 {
+		event.UserID = &user.ID
+	}
+	return tx.RecordEvent(&event)
+}
+
+// Records a lockout event if status is a ban that was just imposed.
+func _RecordLockout(tx storage.Tx, policy LockoutPolicy, status LockoutStatus, user models.User, email string, client ClientInfo) error {
+	if !status.NewBan {
+		return nil
+	}
+	metrics.Lockout(policy.Name)
+	return _RecordAuthEvent(tx, models.AUTH_EVENT_LOCKOUT, user, email, client, policy.Name)
+}
+
+// What a checkpoint's signature covers.
+func _CheckpointPayload(checkpoint models.AuditCheckpoint) []byte {
+	return []byte(fmt.Sprintf(
+		"%d:%d:%s:%s:%s",
+		checkpoint.Number,
+		checkpoint.Seq,
+		checkpoint.Hash,
+		checkpoint.PrevHash,
+		checkpoint.CreatedAt.UTC().Format(time.RFC3339Nano),
+	))
+}
+
+// What the next checkpoint stores as PrevHash, covering the signature too.
+func _CheckpointHash(checkpoint models.AuditCheckpoint) string {
+	sum := sha256.Sum256(append(_CheckpointPayload(checkpoint), ":" + checkpoint.Signature...))
+	return hex.EncodeToString(sum[:])
+}
+
+func _SignCheckpoint(key ed25519.PrivateKey, checkpoint models.AuditCheckpoint) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, _CheckpointPayload(checkpoint)))
+}
+
+func _CheckpointSignedBy(keys []ed25519.PublicKey, checkpoint models.AuditCheckpoint) bool {
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil {
+		return false
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, _CheckpointPayload(checkpoint), signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// audit.signingKey holds either the 32 byte seed or the whole private key.
+func _AuditSigningKey() (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(auditConfig.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case 0:
+		return nil, errors.New("audit.signingKey is not set.")
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+	return nil, errors.New("audit.signingKey is not an Ed25519 private key.")
+}
+
+// The keys checkpoints are verified with, current one first. The current public
+// key is derived from the signing key when only that is set.
+func _AuditPublicKeys() ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	current := auditConfig.PublicKey
+	if len(current) == 0 && len(auditConfig.SigningKey) > 0 {
+		signingKey, err := _AuditSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signingKey.Public().(ed25519.PublicKey))
+	}
+	for _, b64String := range []string{current, auditConfig.PublicKeyOld} {
+		key, err := base64.StdEncoding.DecodeString(b64String)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			continue
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("audit.publicKey and audit.publicKeyOld must be Ed25519 public keys.")
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+func _Truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	// drops a rune cut in half
+	return strings.ToValidUTF8(s[:maxLength], "")
+}
@@ -1,291 +1,416 @@
 package dbhelper
 
 import (
+	"github.com/shoppingapp/apiv1/metrics"
 	"github.com/shoppingapp/apiv1/utils"
 	"github.com/shoppingapp/apiv1/models"
-	"gorm.io/gorm"
+	"github.com/shoppingapp/apiv1/storage"
 	"time"
 	"errors"
-	"fmt"
-	"strings"
+	"log/slog"
 )
 
-func LoginUserWithPassword(email, password string) (string, string, error, string) {
-	tx := DB.Begin()
-	defer tx.Rollback()
-	var accessToken, refreshToken string
-	var loginAttempts models.LoginAttempts
-	var user models.User
-	loginAttempts, err := _GetLoginAttempts(tx, email)
+func LoginUserWithPassword(email, password string, client ClientInfo, captchaSolved bool) (SessionTokens, error) {
+	defer metrics.ObserveDB("LoginUserWithPassword", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_LOGIN, reason)
+	}()
+	err := _EnforceStuffingPolicy(client.IP, captchaSolved)
 	if err != nil {
-		return accessToken, refreshToken, err, utils.GENERIC_LOGIN_ERROR
-	}
-	if time.Now().After(loginAttempts.BanExpiresAt) {
-		loginAttempts.NumAttempts = 0
-	}
-	result := tx.Raw("SELECT * FROM users WHERE email = ?", email).Scan(&user)
-	if result.Error != nil {
-		return accessToken, refreshToken, result.Error, utils.GENERIC_LOGIN_ERROR
+		if AsAuthError(err, utils.GENERIC_LOGIN_ERROR).Code != utils.ERROR_CODE_INTERNAL {
+			reason = metrics.REASON_STUFFING_DEFENSE
+		}
+		return SessionTokens{}, err
 	}
-	compareErr := utils.ComparePasswords(user.PasswordHash, password)
-	loginValid := loginAttempts.NumAttempts < utils.MAX_NUM_LOGIN_ATTEMPTS && result.RowsAffected > 0 && compareErr == nil
-	accessToken, err = utils.CreateJWTToken(user.DisplayName, "access")
+	var tokens SessionTokens
+	// counted before the password is checked, a banned attempt isn't checked at all
+	lockout, err := LoginLockout.RecordAttempt(email)
 	if err != nil {
-		return accessToken, refreshToken, err, utils.GENERIC_LOGIN_ERROR
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
 	}
-	refreshToken, err = utils.CreateJWTToken(user.DisplayName, "refresh")
+	tx, err := Store.Begin()
 	if err != nil {
-		return accessToken, refreshToken, err, utils.GENERIC_LOGIN_ERROR
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
 	}
-	tokenObject := models.RefreshToken{
-		TokenString: refreshToken,
-		User: user,
+	defer tx.Rollback()
+	user, userExists, err := tx.GetUserByEmail(email)
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
 	}
+	compareErr := utils.ComparePasswords(user.PasswordHash, password)
+	loginValid := !lockout.Banned && userExists && compareErr == nil
 	if loginValid {
-		tokenResult := tx.Create(&tokenObject)
-		if tokenResult.Error != nil {
-			return refreshToken, accessToken, tokenResult.Error, utils.GENERIC_LOGIN_ERROR
+		tokens, err = _CreateTokens(user, "")
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
+		}
+		err = tx.CreateSession(user.ID, tokens.RefreshToken)
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
+		}
+		err = _RecordAuthEvent(tx, models.AUTH_EVENT_LOGIN_SUCCESS, user, email, client, "")
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
 		}
-		loginAttempts.NumAttempts = 0
 	} else {
-		if loginAttempts.NumAttempts < utils.MAX_NUM_LOGIN_ATTEMPTS {
-			loginAttempts.NumAttempts++
-			loginAttempts.BanExpiresAt = time.Now().Add(time.Minute * utils.LOGIN_BAN_DURATION)
+		err = _RecordLoginFailure(client.IP)
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
 		}
-	}
-	updateResult := tx.Save(&loginAttempts)
-	if updateResult.Error != nil {
-		return refreshToken, accessToken, updateResult.Error, utils.GENERIC_LOGIN_ERROR
+		failure := metrics.REASON_WRONG_PASSWORD
+		if lockout.Banned {
+			failure = metrics.REASON_LOCKED_OUT
+		} else if !userExists {
+			failure = metrics.REASON_UNKNOWN_EMAIL
+		}
+		err = _RecordAuthEvent(tx, models.AUTH_EVENT_LOGIN_FAILURE, user, email, client, failure)
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
+		}
+		err = _RecordLockout(tx, LoginLockout, lockout, user, email, client)
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.GENERIC_LOGIN_ERROR)
+		}
+		reason = failure
 	}
 	tx.Commit()
 	if loginValid {
-		return accessToken, refreshToken, nil, ""
+		reason = metrics.REASON_NONE
+		err = LoginLockout.Reset(email)
+		if err != nil {
+			slog.Error("Couldn't reset login lockout", "err", err)
+		}
+		return tokens, nil
 	} else {
-		if loginAttempts.NumAttempts == utils.MAX_NUM_LOGIN_ATTEMPTS {
-			errorMessage := utils.GenerateBanMessage(loginAttempts.BanExpiresAt)
-			return refreshToken, accessToken, errors.New(errorMessage), errorMessage
+		if lockout.Banned {
+			return SessionTokens{}, _BanError(lockout.BanExpiresAt)
 		}
-		return refreshToken, accessToken, errors.New("Login unsuccessful."), utils.GENERIC_LOGIN_ERROR
+		return SessionTokens{}, _InvalidCredentialsError(LoginLockout, lockout)
 	}
 }
 
-func CreateUser(email, displayName, passwordHash, refreshToken string) (error, string) {
-	tx := DB.Begin()
-	defer tx.Rollback()
-	user := models.User{
-		Email: email, 
-		PasswordHash: passwordHash, 
-		DisplayName: displayName,
-		PhoneVerified: false,
+// Creates the user and their first session, returning its tokens. The tokens are
+// issued once the user has an ID to name.
+func CreateUser(email, displayName, passwordHash string, client ClientInfo) (SessionTokens, error) {
+	defer metrics.ObserveDB("CreateUser", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_SIGNUP, reason)
+	}()
+	tx, err := Store.Begin()
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
 	}
-	result := tx.Create(&user)
-	if result.Error != nil {
-		errString := fmt.Sprintf("%v", result.Error)
-		if strings.HasPrefix(errString, utils.GORM_ERR_CODE_DUPLICATE_KEY) {
-			if strings.HasSuffix(errString, "'users.email'") {
-				return result.Error, utils.EMAIL_TAKEN_SIGNUP_ERROR
-			} else if strings.HasSuffix(errString, "'users.display_name'") {
-				return result.Error, utils.DISPLAY_NAME_TAKEN_SIGNUP_ERROR
-			}
+	defer tx.Rollback()
+	user, err := _CreateUser(tx, email, displayName, passwordHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailTaken) {
+			reason = metrics.REASON_EMAIL_TAKEN
+		} else if errors.Is(err, storage.ErrDisplayNameTaken) {
+			reason = metrics.REASON_DISPLAY_NAME_TAKEN
 		}
-		return result.Error, utils.GENERIC_SIGNUP_ERROR
+		return SessionTokens{}, err
 	}
-	tokenObject := models.RefreshToken{
-		TokenString: refreshToken,
-		User: user,
+	tokens, err := _CreateTokens(user, "")
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
+	}
+	err = tx.CreateSession(user.ID, tokens.RefreshToken)
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
 	}
-	result = tx.Create(&tokenObject)
-	if result.Error != nil {
-		return result.Error, utils.GENERIC_SIGNUP_ERROR
+	err = _RecordAuthEvent(tx, models.AUTH_EVENT_SIGNUP, user, email, client, "")
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.GENERIC_SIGNUP_ERROR)
 	}
 	tx.Commit()
-	return nil, ""
+	reason = metrics.REASON_NONE
+	return tokens, nil
 }
 
-func CreatePasswordResetCode(email string) (error, string) {
-	tx := DB.Begin()
-	defer tx.Rollback()
-	var resetAttempts models.PasswordResetAttempts
-	var user models.User
-	resetAttempts, err := _GetPasswordResetAttempts(tx, email)
+// Creates a reset code if email belongs to an account, returning how many more
+// requests email may make before it's locked out. Unknown emails are counted the
+// same way so the answer doesn't tell them apart.
+func CreatePasswordResetCode(email string, client ClientInfo) (int, error) {
+	defer metrics.ObserveDB("CreatePasswordResetCode", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_RESET_REQUEST, reason)
+	}()
+	// counted before anything else, a banned request doesn't create a code
+	lockout, err := ResetRequestLockout.RecordAttempt(email)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
+	}
+	tx, err := Store.Begin()
 	if err != nil {
-		return err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 	}
-	if time.Now().After(resetAttempts.RequestsBanExpiresAt) {
-		resetAttempts.NumRequests = 0
+	defer tx.Rollback()
+	user, userExists, err := tx.GetUserByEmail(email)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 	}
-	userResult := tx.Raw("SELECT * FROM users WHERE email = ?", email).Scan(&user)
-	if userResult.Error != nil {
-		return userResult.Error, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR
+	err = _RecordLockout(tx, ResetRequestLockout, lockout, user, email, client)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 	}
-	userExists := userResult.RowsAffected > 0
 	code := utils.GetVerificationCode()
-	if resetAttempts.NumRequests < utils.MAX_NUM_PASS_RESET_CODES {
-		resetAttempts.NumRequests++
-		resetAttempts.RequestsBanExpiresAt = time.Now().Add(time.Minute * utils.RESET_PASSWORD_REQUEST_BAN_DURATION)
+	if !lockout.Banned {
+		err = _RecordAuthEvent(tx, models.AUTH_EVENT_RESET_REQUESTED, user, email, client, "")
+		if err != nil {
+			return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
+		}
 		resetCode := models.PasswordResetCode{
-			User: user,
+			UserID: user.ID,
 			Code: code, 
-			CodeExpiresAt: time.Now().Add(time.Minute * utils.CODE_DURATION),
+			CodeExpiresAt: time.Now().Add(passwordResetConfig.CodeDuration),
 		}
 		if userExists {
-			codeResult := tx.Create(&resetCode)
-			if codeResult.Error != nil {
-				return codeResult.Error, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR
+			err = tx.CreateResetCode(&resetCode)
+			if err != nil {
+				return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 			}
 			// send email
 		}
 	}
-	updateResult := tx.Save(&resetAttempts)
-	if updateResult.Error != nil {
-		return updateResult.Error, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR
-	}
 	tx.Commit()
-	if resetAttempts.NumRequests < utils.MAX_NUM_PASS_RESET_CODES {
-		return nil, ""
+	if !lockout.Banned {
+		reason = metrics.REASON_NONE
+		return ResetRequestLockout.AttemptsRemaining(lockout), nil
 	} else {
-		errorMessage := utils.GenerateBanMessage(resetAttempts.RequestsBanExpiresAt)
-		return errors.New(errorMessage), errorMessage
+		reason = metrics.REASON_LOCKED_OUT
+		return 0, _BanError(lockout.BanExpiresAt)
 	}
 }
 
-func VerifyPasswordResetCode(email, code, passwordHash string) (error, string) {
-	tx := DB.Begin()
-	defer tx.Rollback()
-	var resetAttempts models.PasswordResetAttempts
-	var user models.User
-	var resetCode models.PasswordResetCode
-	// Block logins from happening
-	_, err := _GetLoginAttempts(tx, email)
-	resetAttempts, err = _GetPasswordResetAttempts(tx, email)
+// Sets the password if code is valid, returning how many more attempts email may
+// make before it's locked out. A wrong code isn't an error, so the answer doesn't
+// tell whether email belongs to an account.
+func VerifyPasswordResetCode(email, code, passwordHash string, client ClientInfo) (int, error) {
+	defer metrics.ObserveDB("VerifyPasswordResetCode", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_RESET, reason)
+	}()
+	// counted before the code is checked, a banned attempt isn't checked at all
+	lockout, err := ResetAttemptLockout.RecordAttempt(email)
 	if err != nil {
-		return err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 	}
-	if time.Now().After(resetAttempts.AttemptsBanExpiresAt) {
-		resetAttempts.NumAttempts = 0
+	tx, err := Store.Begin()
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 	}
-	userResult := tx.Raw("SELECT * FROM users WHERE email = ? FOR UPDATE", email).Scan(&user)
-	if userResult.Error != nil {
-		return userResult.Error, utils.GENERIC_PASSWORD_RESET_ERROR
+	defer tx.Rollback()
+	user, _, err := tx.LockUserByEmail(email)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 	}
-	codeResult := tx.Raw("SELECT * FROM password_reset_codes WHERE user_id = ? AND code = ?", user.ID, code).Scan(&resetCode)
-	if codeResult.Error != nil {
-		return codeResult.Error, utils.GENERIC_PASSWORD_RESET_ERROR
+	err = _RecordLockout(tx, ResetAttemptLockout, lockout, user, email, client)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 	}
-	codeValid := codeResult.RowsAffected > 0 && time.Now().Before(resetCode.CodeExpiresAt)
-	if resetAttempts.NumAttempts < utils.MAX_NUM_PASS_RESET_ATTEMPTS {
+	resetCode, codeExists, err := tx.GetResetCode(user.ID, code)
+	if err != nil {
+		return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
+	}
+	codeValid := codeExists && time.Now().Before(resetCode.CodeExpiresAt)
+	passwordReset := false
+	if !lockout.Banned {
 		if codeValid  {
-			resetAttempts.NumAttempts = 0
 			user.PasswordHash = passwordHash
-			updateResult := tx.Save(&user)
-			if updateResult.Error != nil {
-				return updateResult.Error, utils.GENERIC_PASSWORD_RESET_ERROR
+			err = tx.UpdateUser(&user)
+			if err != nil {
+				return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
+			}
+			err = tx.DeleteResetCode(resetCode.ID)
+			if err != nil {
+				return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 			}
-			codeDelete := tx.Exec("DELETE FROM password_reset_codes WHERE id = ?", resetCode.ID)
-			if codeDelete.Error != nil {
-				return codeDelete.Error, utils.GENERIC_PASSWORD_RESET_ERROR
+			_, err = tx.DeleteSessions(user.ID)
+			if err != nil {
+				return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 			}
-			tokenDelete := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", user.ID)
-			if tokenDelete.Error != nil {
-				return tokenDelete.Error, utils.GENERIC_PASSWORD_RESET_ERROR
+			err = _RecordAuthEvent(tx, models.AUTH_EVENT_RESET_COMPLETED, user, email, client, "")
+			if err != nil {
+				return 0, _InternalError(err, utils.GENERIC_PASSWORD_RESET_ERROR)
 			}
+			passwordReset = true
 			// send email notifying of password change
-		} else {
-			resetAttempts.NumAttempts++
-			resetAttempts.AttemptsBanExpiresAt = time.Now().Add(time.Minute * utils.RESET_PASSWORD_BAN_DURATION)
 		}
 	}
-	updateResult := tx.Save(&resetAttempts)
-	if updateResult.Error != nil {
-		return updateResult.Error, utils.GENERIC_PASSWORD_RESET_ERROR
-	}
 	tx.Commit()
-	if resetAttempts.NumAttempts < utils.MAX_NUM_PASS_RESET_ATTEMPTS {
-		return nil, ""
+	if passwordReset {
+		reason = metrics.REASON_NONE
+		err = ResetAttemptLockout.Reset(email)
+		if err != nil {
+			slog.Error("Couldn't reset password reset lockout", "err", err)
+		}
+		lockout = LockoutStatus{}
 	} else {
-		errorMessage := utils.GenerateBanMessage(resetAttempts.AttemptsBanExpiresAt)
-		return errors.New(errorMessage), errorMessage
+		reason = metrics.REASON_INVALID_CODE
+	}
+	if !lockout.Banned {
+		return ResetAttemptLockout.AttemptsRemaining(lockout), nil
+	} else {
+		reason = metrics.REASON_LOCKED_OUT
+		return 0, _BanError(lockout.BanExpiresAt)
 	}
 }
 
-func ReplaceRefreshToken(displayName, oldTokenString, newTokenString string) (error, string) {
-	tx := DB.Begin()
+// Rotates the refresh token oldTokenString of the user, returning the session's
+// new tokens. The session keeps sessionID, and gets one if its token predates
+// session IDs.
+func ReplaceRefreshToken(userID uint, sessionID, oldTokenString string, client ClientInfo) (SessionTokens, error) {
+	defer metrics.ObserveDB("ReplaceRefreshToken", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_REFRESH, reason)
+	}()
+	tx, err := Store.Begin()
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.SERVER_DOWN)
+	}
 	defer tx.Rollback()
-	var user models.User
-	var refreshToken models.RefreshToken
-	tx.Raw("SELECT * FROM users WHERE display_name = ?", displayName).Scan(&user)
-	tokenResult := tx.Raw(
-		"SELECT * FROM refresh_tokens WHERE token_string = ? AND user_id = ? FOR UPDATE", 
-		oldTokenString, 
-		user.ID,
-	).Scan(&refreshToken)
-	if tokenResult.Error != nil {
-		return tokenResult.Error, utils.SERVER_DOWN
-	}
-	tokenExists := tokenResult.RowsAffected > 0
-	refreshToken.TokenString = newTokenString
+	user, userExists, err := tx.GetUserByID(userID)
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.SERVER_DOWN)
+	}
+	if !userExists {
+		reason = metrics.REASON_INVALID_TOKEN
+		return SessionTokens{}, ErrInvalidToken
+	}
+	tokens, err := _CreateTokens(user, sessionID)
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.SERVER_DOWN)
+	}
+	tokenExists, err := tx.ReplaceSession(user.ID, oldTokenString, tokens.RefreshToken)
+	if err != nil {
+		return SessionTokens{}, _InternalError(err, utils.SERVER_DOWN)
+	}
 	if tokenExists {
-		updateResult := tx.Save(&refreshToken)
-		if updateResult.Error != nil {
-			return updateResult.Error, utils.SERVER_DOWN
+		err = _RecordAuthEvent(tx, models.AUTH_EVENT_TOKEN_ROTATED, user, user.Email, client, "")
+		if err != nil {
+			return SessionTokens{}, _InternalError(err, utils.SERVER_DOWN)
 		}
 	}
 	tx.Commit()
 	if tokenExists {
-		return nil, "";
+		reason = metrics.REASON_NONE
+		return tokens, nil
 	}
-	return errors.New(utils.JWT_TOKEN_PARSING_ERROR), utils.JWT_TOKEN_PARSING_ERROR;
+	reason = metrics.REASON_INVALID_TOKEN
+	return SessionTokens{}, ErrInvalidToken
 }
 
-func UpdateDisplayName(email, newDisplayName string) (error, string) {
-	tx := DB.Begin()
+// Ends the session whose refresh token is tokenString. A session that's already
+// gone isn't an error, so signing out twice is harmless.
+func RevokeRefreshToken(userID uint, tokenString string, client ClientInfo) error {
+	defer metrics.ObserveDB("RevokeRefreshToken", time.Now())
+	reason := metrics.REASON_ERROR
+	defer func() {
+		metrics.AuthOutcome(metrics.FLOW_REVOKE, reason)
+	}()
+	tx, err := Store.Begin()
+	if err != nil {
+		return _InternalError(err, utils.SERVER_DOWN)
+	}
 	defer tx.Rollback()
-	result := tx.Exec("UPDATE users SET display_name = ? WHERE email = ?", newDisplayName, email)
-	if result.Error != nil {
-		return result.Error, utils.SERVER_DOWN
+	user, _, err := tx.GetUserByID(userID)
+	if err != nil {
+		return _InternalError(err, utils.SERVER_DOWN)
+	}
+	deleted, err := tx.DeleteSession(userID, tokenString)
+	if err != nil {
+		return _InternalError(err, utils.SERVER_DOWN)
+	}
+	if deleted {
+		err = _RecordAuthEvent(tx, models.AUTH_EVENT_SESSION_REVOKED, user, user.Email, client, "")
+		if err != nil {
+			return _InternalError(err, utils.SERVER_DOWN)
+		}
 	}
 	tx.Commit()
-	return nil, ""
+	reason = metrics.REASON_NONE
+	if !deleted {
+		reason = metrics.REASON_INVALID_TOKEN
+	}
+	return nil
 }
 
-func _GetLoginAttempts(tx *gorm.DB, email string) (models.LoginAttempts, error) {
-	var loginAttempts models.LoginAttempts
-	result := tx.Raw("SELECT * FROM login_attempts WHERE email = ? FOR UPDATE", email).Scan(&loginAttempts)
-	if result.Error != nil {
-		return loginAttempts, result.Error
-	}
-	if result.RowsAffected == 0 {
-		loginAttempts = models.LoginAttempts{
-			Email: email,
-			NumAttempts: 0,
-			BanExpiresAt: time.Now(),
-		}
-		createResult := tx.Create(&loginAttempts)
-		if createResult.Error != nil {
-			return loginAttempts, createResult.Error
+func CountSessions() (int64, error) {
+	defer metrics.ObserveDB("CountSessions", time.Now())
+	return Store.CountSessions()
+}
+
+func UpdateDisplayName(email, newDisplayName string) error {
+	defer metrics.ObserveDB("UpdateDisplayName", time.Now())
+	tx, err := Store.Begin()
+	if err != nil {
+		return _InternalError(err, utils.SERVER_DOWN)
+	}
+	defer tx.Rollback()
+	user, userExists, err := tx.LockUserByEmail(email)
+	if err != nil {
+		return _InternalError(err, utils.SERVER_DOWN)
+	}
+	if userExists {
+		user.DisplayName = newDisplayName
+		err = tx.UpdateUser(&user)
+		if err != nil {
+			return _InternalError(err, utils.SERVER_DOWN)
 		}
 	}
-	return loginAttempts, nil
+	tx.Commit()
+	return nil
 }
 
-func _GetPasswordResetAttempts(tx *gorm.DB, email string) (models.PasswordResetAttempts, error) {
-	var resetAttempts models.PasswordResetAttempts
-	result := tx.Raw("SELECT * FROM password_reset_attempts WHERE email = ? FOR UPDATE", email).Scan(&resetAttempts)
-	if result.Error != nil {
-		return resetAttempts, result.Error
-	}
-	if result.RowsAffected == 0 {
-		resetAttempts = models.PasswordResetAttempts{
-			Email: email,
-			NumRequests: 0,
-			RequestsBanExpiresAt: time.Now(),
-			NumAttempts: 0,
-			AttemptsBanExpiresAt: time.Now(),
-		}
-		createResult := tx.Create(&resetAttempts)
-		if createResult.Error != nil {
-			return resetAttempts, createResult.Error
+// What a client holds for a session. The CSRF token is only checked with cookie
+// sessions, see utils.CreateCSRFToken.
+type SessionTokens struct {
+	AccessToken string
+	RefreshToken string
+	CSRFToken string
+}
+
+// Issues the tokens of user's session sessionID, or of a new session when it's
+// empty, before the session is stored so it's never without its CSRF token.
+func _CreateTokens(user models.User, sessionID string) (SessionTokens, error) {
+	var err error
+	if len(sessionID) == 0 {
+		sessionID, err = utils.GenerateTokenID()
+		if err != nil {
+			return SessionTokens{}, err
 		}
 	}
-	return resetAttempts, nil
-}
\ No newline at end of file
+	var tokens SessionTokens
+	tokens.AccessToken, err = utils.CreateJWTToken(user.ID, utils.ACCESS_TYPE, sessionID)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	tokens.RefreshToken, err = utils.CreateJWTToken(user.ID, utils.REFRESH_TYPE, sessionID)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	tokens.CSRFToken, err = utils.CreateCSRFToken(sessionID)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return tokens, nil
+}
+
+func _CreateUser(tx storage.Tx, email, displayName, passwordHash string) (models.User, error) {
+	user := models.User{
+		Email: email,
+		PasswordHash: passwordHash,
+		DisplayName: displayName,
+		PhoneVerified: false,
+	}
+	err := tx.CreateUser(&user)
+	if err != nil {
+		return user, _DuplicateKeyError(err, utils.GENERIC_SIGNUP_ERROR)
+	}
+	return user, nil
+}
@@ -0,0 +1,116 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/storage"
+	"github.com/shoppingapp/apiv1/utils"
+	"errors"
+	"time"
+)
+
+// The outcome of a failed auth flow. Code is one of the utils.ERROR_CODE_*
+// constants and Message is safe to show users. Retryable says whether the same
+// request can succeed later (after BanExpiresAt for lockouts, after solving a
+// captcha, or once the server recovers), AttemptsRemaining how many more failures
+// lock the account out when AttemptsCounted, and Err is the cause, for logs.
+type AuthError struct {
+	Code string
+	Message string
+	Retryable bool
+	BanExpiresAt time.Time
+	AttemptsCounted bool
+	AttemptsRemaining int
+	Err error
+}
+
+var ErrInvalidCredentials = &AuthError{
+	Code: utils.ERROR_CODE_INVALID_CREDENTIALS,
+	Message: utils.GENERIC_LOGIN_ERROR,
+}
+
+var ErrInvalidToken = &AuthError{
+	Code: utils.ERROR_CODE_INVALID_TOKEN,
+	Message: utils.JWT_TOKEN_PARSING_ERROR,
+}
+
+var ErrCaptchaRequired = &AuthError{
+	Code: utils.ERROR_CODE_CAPTCHA_REQUIRED,
+	Message: utils.CAPTCHA_REQUIRED_ERROR,
+	Retryable: true,
+}
+
+func (e *AuthError) Error() string {
+	if e.Err != nil {
+		return e.Message + " (" + e.Err.Error() + ")"
+	}
+	return e.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// AuthErrors match the sentinels above by code, so errors.Is(err,
+// ErrInvalidCredentials) holds whatever the attempts left.
+func (e *AuthError) Is(target error) bool {
+	authErr, ok := target.(*AuthError)
+	return ok && authErr.Code == e.Code
+}
+
+func (e *AuthError) Banned() bool {
+	return !e.BanExpiresAt.IsZero()
+}
+
+func (e *AuthError) APIError() utils.APIError {
+	apiErr := utils.NewAPIError(utils.ErrorStatus(e.Code), e.Code, e.Message)
+	apiErr.Retryable = e.Retryable
+	if e.Banned() {
+		bannedUntil := e.BanExpiresAt.UTC()
+		apiErr.Lockout = &utils.LockoutDetails{BannedUntil: &bannedUntil}
+	} else if e.AttemptsCounted {
+		apiErr.Lockout = &utils.LockoutDetails{AttemptsRemaining: e.AttemptsRemaining}
+	}
+	return apiErr
+}
+
+// Returns the AuthError in err, treating any other error as an internal one shown
+// to users as message.
+func AsAuthError(err error, message string) *AuthError {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr
+	}
+	return &AuthError{Code: utils.ERROR_CODE_INTERNAL, Message: message, Retryable: true, Err: err}
+}
+
+func _InternalError(err error, message string) error {
+	return AsAuthError(err, message)
+}
+
+func _InvalidCredentialsError(policy LockoutPolicy, status LockoutStatus) error {
+	return &AuthError{
+		Code: ErrInvalidCredentials.Code,
+		Message: ErrInvalidCredentials.Message,
+		AttemptsCounted: true,
+		AttemptsRemaining: policy.AttemptsRemaining(status),
+	}
+}
+
+func _BanError(expiresAt time.Time) error {
+	return &AuthError{
+		Code: utils.ERROR_CODE_LOCKED_OUT,
+		Message: utils.GenerateBanMessage(expiresAt),
+		Retryable: true,
+		BanExpiresAt: expiresAt,
+	}
+}
+
+// Tells users which unique field of their account is taken, if that's what err
+// is about.
+func _DuplicateKeyError(err error, message string) error {
+	if errors.Is(err, storage.ErrEmailTaken) {
+		return &AuthError{Code: utils.ERROR_CODE_EMAIL_TAKEN, Message: utils.EMAIL_TAKEN_SIGNUP_ERROR, Err: err}
+	} else if errors.Is(err, storage.ErrDisplayNameTaken) {
+		return &AuthError{Code: utils.ERROR_CODE_DISPLAY_NAME_TAKEN, Message: utils.DISPLAY_NAME_TAKEN_SIGNUP_ERROR, Err: err}
+	}
+	return _InternalError(err, message)
+}
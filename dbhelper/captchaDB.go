@@ -0,0 +1,26 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+)
+
+// Reports whether a client must solve a captcha before trying again, either because
+// id has made at least threshold attempts under policy or because its IP tripped a
+// credential stuffing rule whose action is captcha. policy is nil for endpoints
+// without a lockout, like signup.
+func IsCaptchaRequired(policy *LockoutPolicy, threshold int, id, ip string) (bool, error) {
+	if policy != nil {
+		lockout, err := policy.Status(id)
+		if err != nil {
+			return false, err
+		}
+		if lockout.Banned || lockout.Attempts >= int64(threshold) {
+			return true, nil
+		}
+	}
+	action, _, err := _CheckStuffing(ip)
+	if err != nil {
+		return false, err
+	}
+	return action == config.STUFFING_ACTION_CAPTCHA, nil
+}
\ No newline at end of file
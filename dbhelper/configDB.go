@@ -1,34 +1,223 @@
 package dbhelper
 
 import (
-	"github.com/shoppingapp/apiv1/models"
-	"github.com/shoppingapp/apiv1/utils"
+	"github.com/shoppingapp/apiv1/attemptstore"
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/storage"
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
-	"gorm.io/driver/mysql"
+	"crypto/tls"
+	"context"
+	"crypto/x509"
+	"sync/atomic"
+	"errors"
+	"time"
 	"fmt"
+	"log/slog"
+	"io"
 	"os"
 )
 
+// nil with the memory driver, everything else should go through Store
 var DB *gorm.DB
+var replicas []*gorm.DB
+var nextReplica uint64
+var Store storage.Store
+var Attempts storage.AttemptStore
+var passwordResetConfig config.PasswordResetConfig
 
-func OpenDB() error {
+// Connects to the primary and every read replica, retrying with exponential
+// backoff while the database is still starting up.
+func OpenDB(cfg config.DatabaseConfig) error {
+	if cfg.Driver == config.DATABASE_DRIVER_MEMORY {
+		DB = nil
+		Store = storage.NewMemoryStore()
+		return nil
+	}
+	tlsConfig, err := _DBTLSConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+	primary := dbAddress{Host: cfg.Host, Port: cfg.Port, Socket: cfg.Socket}
+	DB, err = _OpenDBWithRetry(cfg, primary, tlsConfig)
+	if err != nil {
+		return err
+	}
+	replicas = nil
+	for _, addr := range cfg.ReadReplicas {
+		// replicas are always reached over TCP, even when the primary uses a socket
+		replicaAddress, err := _ParseDBAddress(addr)
+		if err != nil {
+			return err
+		}
+		// database.tls.serverName names the primary, each replica is checked against its own host
+		replica, err := _OpenDBWithRetry(cfg, replicaAddress, _WithServerName(tlsConfig, replicaAddress.Host))
+		if err != nil {
+			return err
+		}
+		replicas = append(replicas, replica)
+	}
+	Store = storage.NewGormStore(DB, ReadDB)
+	return nil
+}
+
+// Returns a connection for read-only queries outside transactions, spreading them
+// over the read replicas. Replicas may lag behind, so anything that must see its own
+// writes should use DB.
+func ReadDB() *gorm.DB {
+	if len(replicas) == 0 {
+		return DB
+	}
+	next := atomic.AddUint64(&nextReplica, 1)
+	return replicas[next % uint64(len(replicas))]
+}
+
+// Closes the primary, the read replicas and the attempt store's connections. The
+// memory driver and attempt store have nothing to close.
+func CloseDB() {
+	for _, db := range append([]*gorm.DB{DB}, replicas...) {
+		_CloseDB(db)
+	}
+	if closer, ok := Attempts.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// Checks that the primary and every read replica still answer.
+func PingDB(ctx context.Context) error {
+	if DB == nil {
+		// the memory driver has nothing to reach
+		return nil
+	}
+	for i, db := range append([]*gorm.DB{DB}, replicas...) {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil && i == 0 {
+			return errors.New(fmt.Sprintf("The primary database is unreachable: %v", err))
+		} else if err != nil {
+			return errors.New(fmt.Sprintf("Read replica %d is unreachable: %v", i, err))
+		}
+	}
+	return nil
+}
+
+func _OpenDBWithRetry(cfg config.DatabaseConfig, address dbAddress, tlsConfig *tls.Config) (*gorm.DB, error) {
+	var db *gorm.DB
+	var err error
+	backoff := cfg.ConnectBackoff
+	for attempt := 1; attempt <= cfg.ConnectAttempts; attempt++ {
+		var dialector gorm.Dialector
+		dialector, err = _Dialector(cfg, address, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		db, err = gorm.Open(dialector, &gorm.Config{})
+		if err == nil {
+			break
+		}
+		_CloseDB(db)
+		if attempt < cfg.ConnectAttempts {
+			slog.Warn("Couldn't connect to the database, retrying",
+				"address", address.String(),
+				"attempt", attempt,
+				"max_attempts", cfg.ConnectAttempts,
+				"retry_in", backoff.String(),
+				"err", err,
+			)
+			time.Sleep(backoff)
+			backoff = _NextBackoff(backoff)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	return db, nil
+}
+
+// Closes the connection pool a failed gorm.Open may have left behind.
+func _CloseDB(db *gorm.DB) {
+	if db == nil || db.Config == nil || db.ConnPool == nil {
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+func _NextBackoff(backoff time.Duration) time.Duration {
+	const MAX_BACKOFF = 30 * time.Second
+	if backoff * 2 > MAX_BACKOFF {
+		return MAX_BACKOFF
+	}
+	return backoff * 2
+}
+
+// Returns a copy of tlsConfig that verifies the server as serverName.
+func _WithServerName(tlsConfig *tls.Config, serverName string) *tls.Config {
+	if tlsConfig == nil {
+		return nil
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = serverName
+	return tlsConfig
+}
+
+func _DBTLSConfig(cfg config.DatabaseTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(cfg.CAFile) > 0 {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in %s.", cfg.CAFile))
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if len(cfg.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func OpenAttemptStore(cfg config.AttemptStoreConfig) error {
 	var err error
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(127.0.0.1:3306)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		os.Getenv(utils.DBUSER),
-		os.Getenv(utils.DBPASS),
-		os.Getenv(utils.DBNAME),
-	)
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	switch cfg.Backend {
+	case config.ATTEMPT_STORE_MEMORY:
+		Attempts = attemptstore.NewMemoryStore(time.Minute)
+	case config.ATTEMPT_STORE_REDIS:
+		Attempts, err = attemptstore.NewRedisStore(&redis.Options{
+			Addr: cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB: cfg.RedisDB,
+		}, "auth:")
+	default:
+		err = errors.New(fmt.Sprintf("Unknown attempt store %q.", cfg.Backend))
+	}
 	return err
 }
 
-func InitDB() error {
-	return DB.AutoMigrate(
-		&models.User{},
-		&models.LoginAttempts{}, 
-		&models.PasswordResetAttempts{},
-		&models.PasswordResetCode{}, 
-		&models.RefreshToken{},
-	)
+func SetPasswordResetConfig(cfg config.PasswordResetConfig) {
+	passwordResetConfig = cfg
 }
\ No newline at end of file
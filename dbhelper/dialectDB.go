@@ -0,0 +1,127 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"gorm.io/gorm"
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"crypto/tls"
+	"net/url"
+	"strconv"
+	"errors"
+	"time"
+	"fmt"
+	"net"
+)
+
+// Where to reach a database server: Socket wins over Host and Port when set, and a
+// zero Port is the driver's default.
+type dbAddress struct {
+	Host string
+	Port int
+	Socket string
+}
+
+func (a dbAddress) String() string {
+	if len(a.Socket) > 0 {
+		return a.Socket
+	}
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// Parses a read replica's "host[:port]".
+func _ParseDBAddress(addr string) (dbAddress, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port given
+		return dbAddress{Host: addr}, nil
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return dbAddress{}, errors.New(fmt.Sprintf("Invalid read replica address %q.", addr))
+	}
+	return dbAddress{Host: host, Port: port}, nil
+}
+
+func _Dialector(cfg config.DatabaseConfig, address dbAddress, tlsConfig *tls.Config) (gorm.Dialector, error) {
+	switch cfg.Driver {
+	case config.DATABASE_DRIVER_MYSQL:
+		return _MySQLDialector(cfg, address, tlsConfig)
+	case config.DATABASE_DRIVER_POSTGRES:
+		return _PostgresDialector(cfg, address, tlsConfig)
+	case config.DATABASE_DRIVER_SQLITE:
+		// SQLite has no row locks, so every transaction takes the write lock when it
+		// begins instead of at its first write, serializing them like FOR UPDATE would
+		return sqlite.Open(cfg.Name + "?_busy_timeout=5000&_txlock=immediate&_foreign_keys=on"), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown database driver %q.", cfg.Driver))
+}
+
+func _MySQLDialector(cfg config.DatabaseConfig, address dbAddress, tlsConfig *tls.Config) (gorm.Dialector, error) {
+	const DEFAULT_PORT = 3306
+	mysqlConfig := mysql.NewConfig()
+	mysqlConfig.User = cfg.User
+	mysqlConfig.Passwd = cfg.Password
+	mysqlConfig.DBName = cfg.Name
+	if len(address.Socket) > 0 {
+		mysqlConfig.Net = "unix"
+		mysqlConfig.Addr = address.Socket
+	} else {
+		if address.Port == 0 {
+			address.Port = DEFAULT_PORT
+		}
+		mysqlConfig.Net = "tcp"
+		mysqlConfig.Addr = address.String()
+	}
+	if tlsConfig != nil {
+		// the driver fills in ServerName from Addr when it's empty. Configs are
+		// registered per server, so replicas don't verify against each other's names
+		tlsConfigName := "dbhelper:" + address.String()
+		err := mysql.RegisterTLSConfig(tlsConfigName, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		mysqlConfig.TLSConfig = tlsConfigName
+	}
+	mysqlConfig.ParseTime = true
+	mysqlConfig.Loc = time.Local
+	mysqlConfig.Params = map[string]string{"charset": "utf8mb4"}
+	return gormmysql.Open(mysqlConfig.FormatDSN()), nil
+}
+
+func _PostgresDialector(cfg config.DatabaseConfig, address dbAddress, tlsConfig *tls.Config) (gorm.Dialector, error) {
+	const DEFAULT_PORT = 5432
+	if address.Port == 0 {
+		address.Port = DEFAULT_PORT
+	}
+	params := url.Values{}
+	// TLS is set up below from our own tls.Config rather than pgx's sslmode
+	params.Set("sslmode", "disable")
+	dsn := url.URL{
+		Scheme: "postgres",
+		User: url.UserPassword(cfg.User, cfg.Password),
+		Host: net.JoinHostPort(address.Host, strconv.Itoa(address.Port)),
+		Path: "/" + cfg.Name,
+	}
+	if len(address.Socket) > 0 {
+		dsn.Host = ""
+		params.Set("host", address.Socket)
+		params.Set("port", strconv.Itoa(address.Port))
+	}
+	dsn.RawQuery = params.Encode()
+	connConfig, err := pgx.ParseConfig(dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && len(address.Socket) == 0 {
+		connConfig.TLSConfig = tlsConfig.Clone()
+		if len(connConfig.TLSConfig.ServerName) == 0 {
+			connConfig.TLSConfig.ServerName = address.Host
+		}
+	}
+	return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*connConfig)}), nil
+}
@@ -0,0 +1,168 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/attemptstore"
+	"github.com/shoppingapp/apiv1/config"
+	"math"
+	"time"
+)
+
+// Bans an identifier (an email) for BaseBan after MaxAttempts attempts within
+// AttemptWindow. Every ban within HistoryDuration of the previous one is
+// Multiplier times longer, up to MaxBan, and the history is forgotten once
+// HistoryDuration passes without a ban.
+type LockoutPolicy struct {
+	Name string
+	MaxAttempts int
+	AttemptWindow time.Duration
+	BaseBan time.Duration
+	Multiplier float64
+	MaxBan time.Duration
+	HistoryDuration time.Duration
+}
+
+// NewBan is set on the attempt that imposed the ban, so it's reported once.
+type LockoutStatus struct {
+	Attempts int64
+	Banned bool
+	NewBan bool
+	BanExpiresAt time.Time
+}
+
+var LoginLockout = LockoutPolicy{Name: "login"}
+var ResetRequestLockout = LockoutPolicy{Name: "reset_request"}
+var ResetAttemptLockout = LockoutPolicy{Name: "reset_attempt"}
+
+func SetLockoutPolicies(cfg config.LockoutConfig) {
+	LoginLockout = _NewLockoutPolicy(LoginLockout.Name, cfg.Login, cfg)
+	ResetRequestLockout = _NewLockoutPolicy(ResetRequestLockout.Name, cfg.ResetRequest, cfg)
+	ResetAttemptLockout = _NewLockoutPolicy(ResetAttemptLockout.Name, cfg.ResetAttempt, cfg)
+}
+
+// Reads the attempts and ban of id without counting anything. It only informs
+// decisions that don't guard the lockout itself, like whether to ask for a
+// captcha, use RecordAttempt to decide whether an attempt may go ahead.
+func (p LockoutPolicy) Status(id string) (LockoutStatus, error) {
+	ban, err := Attempts.Get(p._BanKey(id))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	if ban.Count > 0 {
+		return LockoutStatus{Attempts: int64(p.MaxAttempts), Banned: true, BanExpiresAt: ban.ExpiresAt}, nil
+	}
+	attempts, err := Attempts.Get(p._AttemptsKey(id))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	return LockoutStatus{Attempts: attempts.Count}, nil
+}
+
+// Counts an attempt by id before it is checked and says whether it may go ahead.
+// The decision comes from the count the increment returns, so concurrent attempts
+// can't all read a count under the limit and slip past it. Up to MaxAttempts
+// attempts are allowed, the one after bans id and while banned attempts aren't
+// counted. Success should Reset the count.
+func (p LockoutPolicy) RecordAttempt(id string) (LockoutStatus, error) {
+	ban, err := Attempts.Get(p._BanKey(id))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	if ban.Count > 0 {
+		return LockoutStatus{Attempts: int64(p.MaxAttempts), Banned: true, BanExpiresAt: ban.ExpiresAt}, nil
+	}
+	attempts, err := Attempts.Increment(p._AttemptsKey(id), p.AttemptWindow)
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	if attempts.Count <= int64(p.MaxAttempts) {
+		return LockoutStatus{Attempts: attempts.Count}, nil
+	}
+	if attempts.Count > int64(p.MaxAttempts) + 1 {
+		// another attempt got MaxAttempts+1 and is imposing the ban right now
+		return p._PendingBan(id, attempts)
+	}
+	offenses, err := Attempts.Increment(p._OffensesKey(id), p.HistoryDuration)
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	ban, err = Attempts.Increment(p._BanKey(id), p.BanDuration(offenses.Count))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	err = Attempts.Reset(p._AttemptsKey(id))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	return LockoutStatus{Attempts: int64(p.MaxAttempts), Banned: true, NewBan: true, BanExpiresAt: ban.ExpiresAt}, nil
+}
+
+// How many more attempts id may make before it's banned.
+func (p LockoutPolicy) AttemptsRemaining(status LockoutStatus) int {
+	if status.Banned {
+		return 0
+	}
+	return p.MaxAttempts - int(status.Attempts)
+}
+
+// Length of the ban for the given offense, counting from 1.
+func (p LockoutPolicy) BanDuration(offense int64) time.Duration {
+	ban := float64(p.BaseBan) * math.Pow(p.Multiplier, float64(offense - 1))
+	if ban > float64(p.MaxBan) {
+		return p.MaxBan
+	}
+	return time.Duration(ban)
+}
+
+// Clears the attempts of id. Past bans are kept so repeat offenders still escalate.
+func (p LockoutPolicy) Reset(id string) error {
+	return Attempts.Reset(p._AttemptsKey(id))
+}
+
+// Clears the attempts, current ban and ban history of id.
+func (p LockoutPolicy) Unlock(id string) error {
+	for _, key := range []string{p._AttemptsKey(id), p._BanKey(id), p._OffensesKey(id)} {
+		err := Attempts.Reset(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Refuses an attempt that came in while another one imposes the ban, reporting
+// the ban once it's set or else when the attempts would have expired.
+func (p LockoutPolicy) _PendingBan(id string, attempts attemptstore.Counter) (LockoutStatus, error) {
+	ban, err := Attempts.Get(p._BanKey(id))
+	if err != nil {
+		return LockoutStatus{}, err
+	}
+	expiresAt := ban.ExpiresAt
+	if ban.Count == 0 {
+		expiresAt = attempts.ExpiresAt
+	}
+	return LockoutStatus{Attempts: int64(p.MaxAttempts), Banned: true, BanExpiresAt: expiresAt}, nil
+}
+
+func (p LockoutPolicy) _AttemptsKey(id string) string {
+	return p.Name + ":" + id
+}
+
+func (p LockoutPolicy) _BanKey(id string) string {
+	return p.Name + "_ban:" + id
+}
+
+func (p LockoutPolicy) _OffensesKey(id string) string {
+	return p.Name + "_offenses:" + id
+}
+
+func _NewLockoutPolicy(name string, policy config.LockoutPolicyConfig, cfg config.LockoutConfig) LockoutPolicy {
+	return LockoutPolicy{
+		Name: name,
+		MaxAttempts: policy.MaxAttempts,
+		AttemptWindow: policy.BanDuration,
+		BaseBan: policy.BanDuration,
+		Multiplier: cfg.BanMultiplier,
+		MaxBan: cfg.MaxBan,
+		HistoryDuration: cfg.HistoryDuration,
+	}
+}
\ No newline at end of file
@@ -0,0 +1,205 @@
+package dbhelper
+
+import (
+	"gorm.io/gorm"
+	"strings"
+	"errors"
+	"sort"
+	"time"
+	"fmt"
+)
+
+// A schema change. Up and Down run in a transaction, but MySQL commits DDL
+// statements on its own, so a migration that fails halfway there may need fixing
+// by hand. Migrations must not use the models package since its structs follow the
+// latest schema, not the schema at the time of the migration.
+type Migration struct {
+	Version int
+	Name string
+	Up func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+}
+
+type MigrationStatus struct {
+	Version int
+	Name string
+	Applied bool
+	AppliedAt time.Time
+}
+
+// A row of schema_migrations, one per applied migration.
+type schemaMigration struct {
+	Version int `gorm:"primaryKey;autoIncrement:false"`
+	Name string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations) - 1].Version
+}
+
+// Lists every known migration, plus any applied by a newer release of the server.
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	applied, err := _AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied, status.AppliedAt = true, row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		statuses = append(statuses, MigrationStatus{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: row.AppliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Applies every pending migration up to version and reverts every applied one
+// above it, so version 0 empties the database. Returns the migrations that ran in
+// the order they ran.
+func MigrateTo(version int) ([]Migration, error) {
+	if version != 0 && _FindMigration(version) == nil {
+		return nil, errors.New(fmt.Sprintf("Unknown schema version %d.", version))
+	}
+	err := DB.AutoMigrate(&schemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+	applied, err := _AppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var ran []Migration
+	// revert from the newest down
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+			continue
+		}
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			err := migration.Down(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return ran, errors.New(fmt.Sprintf("Reverting migration %d %s failed: %v", migration.Version, migration.Name, err))
+		}
+		ran = append(ran, migration)
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > version {
+			continue
+		}
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			err := migration.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{
+				Version: migration.Version,
+				Name: migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return ran, errors.New(fmt.Sprintf("Applying migration %d %s failed: %v", migration.Version, migration.Name, err))
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+func MigrateUp() ([]Migration, error) {
+	return MigrateTo(LatestSchemaVersion())
+}
+
+// Reverts the newest applied migration.
+func MigrateDown() ([]Migration, error) {
+	statuses, err := GetMigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+	var appliedVersions []int
+	for _, status := range statuses {
+		if status.Applied {
+			appliedVersions = append(appliedVersions, status.Version)
+		}
+	}
+	if len(appliedVersions) == 0 {
+		return nil, nil
+	}
+	newest := appliedVersions[len(appliedVersions) - 1]
+	if _FindMigration(newest) == nil {
+		return nil, errors.New(fmt.Sprintf("Migration %d was applied by a newer release, revert it with that release.", newest))
+	}
+	previous := 0
+	if len(appliedVersions) > 1 {
+		previous = appliedVersions[len(appliedVersions) - 2]
+	}
+	return MigrateTo(previous)
+}
+
+// Fails unless every migration has been applied, so the server never runs
+// against a schema it doesn't know.
+func CheckSchema() error {
+	if DB == nil {
+		// the memory driver has no schema
+		return nil
+	}
+	applied, err := _AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%d %s", migration.Version, migration.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return errors.New(fmt.Sprintf(
+			"The database is missing migrations %s. Run `authctl migrate up` first.",
+			strings.Join(pending, ", "),
+		))
+	}
+	return nil
+}
+
+func _AppliedMigrations() (map[int]schemaMigration, error) {
+	applied := map[int]schemaMigration{}
+	if !DB.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+	var rows []schemaMigration
+	result := DB.Raw("SELECT * FROM schema_migrations").Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func _FindMigration(version int) *Migration {
+	for i := range migrations {
+		if migrations[i].Version == version {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/metrics"
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/utils"
+	"time"
+)
+
+func GetUserByID(id uint) (models.User, error) {
+	defer metrics.ObserveDB("GetUserByID", time.Now())
+	user, userExists, err := Store.GetUserByID(id)
+	if err != nil {
+		return user, _InternalError(err, utils.GENERIC_PROFILE_ERROR)
+	}
+	if !userExists {
+		// the account was deleted after this token was issued
+		return user, ErrInvalidToken
+	}
+	return user, nil
+}
+
+// Renaming doesn't touch sessions, tokens name the user by ID.
+func UpdateProfile(userID uint, newDisplayName string) (models.User, error) {
+	defer metrics.ObserveDB("UpdateProfile", time.Now())
+	var user models.User
+	tx, err := Store.Begin()
+	if err != nil {
+		return user, _InternalError(err, utils.GENERIC_PROFILE_UPDATE_ERROR)
+	}
+	defer tx.Rollback()
+	user, userExists, err := tx.LockUserByID(userID)
+	if err != nil {
+		return user, _InternalError(err, utils.GENERIC_PROFILE_UPDATE_ERROR)
+	}
+	if !userExists {
+		return user, ErrInvalidToken
+	}
+	if newDisplayName == user.DisplayName {
+		tx.Commit()
+		return user, nil
+	}
+	user.DisplayName = newDisplayName
+	err = tx.UpdateUser(&user)
+	if err != nil {
+		return user, _DuplicateKeyError(err, utils.GENERIC_PROFILE_UPDATE_ERROR)
+	}
+	tx.Commit()
+	return user, nil
+}
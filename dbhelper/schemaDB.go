@@ -0,0 +1,298 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/storage"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Every schema change in order. Append new migrations with the next version and
+// never edit one that has been released.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name: "initial_schema",
+		// AutoMigrate only creates what's missing, so this also adopts databases
+		// set up by older releases, which ran AutoMigrate on every start
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV1{}, &loginAttemptsV1{}, &passwordResetAttemptsV1{}, &passwordResetCodeV1{}, &refreshTokenV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&refreshTokenV1{}, &passwordResetCodeV1{}, &passwordResetAttemptsV1{}, &loginAttemptsV1{}, &userV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name: "add_user_verification_flags",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"EmailVerified", "TwoFactorEnabled"} {
+				if tx.Migrator().HasColumn(&userV2{}, column) {
+					continue
+				}
+				err := tx.Migrator().AddColumn(&userV2{}, column)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, column := range []string{"EmailVerified", "TwoFactorEnabled"} {
+				err := tx.Migrator().DropColumn(&userV2{}, column)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name: "drop_attempt_tables",
+		// lockout counters moved to the attempt store
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&loginAttemptsV1{}, &passwordResetAttemptsV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&loginAttemptsV1{}, &passwordResetAttemptsV1{})
+		},
+	},
+	{
+		Version: 4,
+		Name: "create_auth_events",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&authEventV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&authEventV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name: "add_user_is_admin",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&userV5{}, "IsAdmin")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV5{}, "IsAdmin")
+		},
+	},
+	{
+		Version: 6,
+		Name: "chain_auth_events",
+		Up: func(tx *gorm.DB) error {
+			for _, column := range []string{"Seq", "Hash"} {
+				err := tx.Migrator().AddColumn(&authEventV6{}, column)
+				if err != nil {
+					return err
+				}
+			}
+			// chains the events recorded so far in the order they were recorded
+			head := authEventChainV6{ID: 1}
+			var batch []authEventV6
+			err := tx.Order("id").FindInBatches(&batch, 1000, func(_ *gorm.DB, _ int) error {
+				for _, event := range batch {
+					head.Seq++
+					head.Hash = storage.HashEvent(head.Hash, event._Model(head.Seq))
+					err := tx.Model(&authEventV6{}).Where("id = ?", event.ID).
+						Updates(map[string]interface{}{"seq": head.Seq, "hash": head.Hash}).Error
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Migrator().CreateIndex(&authEventV6{}, "Seq")
+			if err != nil {
+				return err
+			}
+			err = tx.AutoMigrate(&authEventChainV6{}, &auditCheckpointV6{})
+			if err != nil {
+				return err
+			}
+			return tx.Create(&head).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Migrator().DropTable(&auditCheckpointV6{}, &authEventChainV6{})
+			if err != nil {
+				return err
+			}
+			err = tx.Migrator().DropIndex(&authEventV6{}, "Seq")
+			if err != nil {
+				return err
+			}
+			for _, column := range []string{"Hash", "Seq"} {
+				err := tx.Migrator().DropColumn(&authEventV6{}, column)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// Snapshots of the models as of the migration that introduced them, suffixed with
+// that migration's version.
+
+type userV1 struct {
+	gorm.Model
+	Email string `gorm:"unique"`
+	PasswordHash string
+	DisplayName string `gorm:"unique"`
+	PhoneVerified bool
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
+
+type userV2 struct {
+	gorm.Model
+	Email string `gorm:"unique"`
+	PasswordHash string
+	DisplayName string `gorm:"unique"`
+	EmailVerified bool
+	PhoneVerified bool
+	TwoFactorEnabled bool
+}
+
+func (userV2) TableName() string {
+	return "users"
+}
+
+type loginAttemptsV1 struct {
+	gorm.Model
+	Email string `gorm:"unique"`
+	NumAttempts uint
+	BanExpiresAt time.Time
+}
+
+func (loginAttemptsV1) TableName() string {
+	return "login_attempts"
+}
+
+type passwordResetAttemptsV1 struct {
+	gorm.Model
+	Email string `gorm:"unique"`
+	NumRequests uint
+	RequestsBanExpiresAt time.Time
+	NumAttempts uint
+	AttemptsBanExpiresAt time.Time
+}
+
+func (passwordResetAttemptsV1) TableName() string {
+	return "password_reset_attempts"
+}
+
+type passwordResetCodeV1 struct {
+	gorm.Model
+	UserID uint
+	User userV1
+	Code string
+	CodeExpiresAt time.Time
+}
+
+func (passwordResetCodeV1) TableName() string {
+	return "password_reset_codes"
+}
+
+type refreshTokenV1 struct {
+	gorm.Model
+	UserID uint
+	User userV1
+	TokenString string
+}
+
+func (refreshTokenV1) TableName() string {
+	return "refresh_tokens"
+}
+
+type authEventV4 struct {
+	ID uint `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Type string `gorm:"size:32;index"`
+	UserID *uint `gorm:"index"`
+	Email string `gorm:"size:255;index"`
+	IP string `gorm:"size:45"`
+	UserAgent string `gorm:"size:512"`
+	Detail string `gorm:"size:255"`
+}
+
+func (authEventV4) TableName() string {
+	return "auth_events"
+}
+
+type userV5 struct {
+	gorm.Model
+	Email string `gorm:"unique"`
+	PasswordHash string
+	DisplayName string `gorm:"unique"`
+	EmailVerified bool
+	PhoneVerified bool
+	TwoFactorEnabled bool
+	IsAdmin bool
+}
+
+func (userV5) TableName() string {
+	return "users"
+}
+
+type authEventV6 struct {
+	ID uint `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Type string `gorm:"size:32;index"`
+	UserID *uint `gorm:"index"`
+	Email string `gorm:"size:255;index"`
+	IP string `gorm:"size:45"`
+	UserAgent string `gorm:"size:512"`
+	Detail string `gorm:"size:255"`
+	Seq uint `gorm:"uniqueIndex"`
+	Hash string `gorm:"size:64"`
+}
+
+func (authEventV6) TableName() string {
+	return "auth_events"
+}
+
+// The fields storage.HashEvent covers, with seq as the event's place in the chain.
+func (e authEventV6) _Model(seq uint) models.AuthEvent {
+	return models.AuthEvent{
+		CreatedAt: e.CreatedAt,
+		Type: e.Type,
+		UserID: e.UserID,
+		Email: e.Email,
+		IP: e.IP,
+		UserAgent: e.UserAgent,
+		Detail: e.Detail,
+		Seq: seq,
+	}
+}
+
+type authEventChainV6 struct {
+	ID uint `gorm:"primarykey"`
+	Seq uint
+	Hash string `gorm:"size:64"`
+}
+
+func (authEventChainV6) TableName() string {
+	return "auth_event_chain"
+}
+
+type auditCheckpointV6 struct {
+	ID uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Number uint `gorm:"uniqueIndex"`
+	Seq uint `gorm:"index"`
+	Hash string `gorm:"size:64"`
+	PrevHash string `gorm:"size:64"`
+	Signature string `gorm:"size:128"`
+}
+
+func (auditCheckpointV6) TableName() string {
+	return "audit_checkpoints"
+}
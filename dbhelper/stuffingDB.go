@@ -0,0 +1,100 @@
+package dbhelper
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/utils"
+	"time"
+)
+
+// Per-email lockouts don't slow down an attacker trying one password against
+// many emails, so failed logins are also counted per IP, per subnet and globally.
+type StuffingRule struct {
+	MaxFailures int
+	Window time.Duration
+	Action string
+}
+
+type StuffingPolicy struct {
+	PerIP StuffingRule
+	PerSubnet StuffingRule
+	Global StuffingRule
+	Delay time.Duration
+}
+
+var Stuffing StuffingPolicy
+
+var stuffingActionSeverity = map[string]int{
+	config.STUFFING_ACTION_NONE: 0,
+	config.STUFFING_ACTION_DELAY: 1,
+	config.STUFFING_ACTION_CAPTCHA: 2,
+	config.STUFFING_ACTION_BLOCK: 3,
+}
+
+func SetStuffingPolicy(cfg config.StuffingConfig) {
+	Stuffing = StuffingPolicy{
+		PerIP: StuffingRule{MaxFailures: cfg.IPLimit.Count, Window: cfg.IPLimit.Per, Action: cfg.IPAction},
+		PerSubnet: StuffingRule{MaxFailures: cfg.SubnetLimit.Count, Window: cfg.SubnetLimit.Per, Action: cfg.SubnetAction},
+		Global: StuffingRule{MaxFailures: cfg.GlobalLimit.Count, Window: cfg.GlobalLimit.Per, Action: cfg.GlobalAction},
+		Delay: cfg.Delay,
+	}
+}
+
+// Returns the most severe action triggered for a login from ip and when it lifts.
+func _CheckStuffing(ip string) (string, time.Time, error) {
+	action := config.STUFFING_ACTION_NONE
+	var expiresAt time.Time
+	for key, rule := range _StuffingCounters(ip) {
+		counter, err := Attempts.Get(key)
+		if err != nil {
+			return action, expiresAt, err
+		}
+		if counter.Count < int64(rule.MaxFailures) {
+			continue
+		}
+		if stuffingActionSeverity[rule.Action] > stuffingActionSeverity[action] {
+			action = rule.Action
+			expiresAt = counter.ExpiresAt
+		} else if rule.Action == action && counter.ExpiresAt.After(expiresAt) {
+			expiresAt = counter.ExpiresAt
+		}
+	}
+	return action, expiresAt, nil
+}
+
+func _RecordLoginFailure(ip string) error {
+	for key, rule := range _StuffingCounters(ip) {
+		_, err := Attempts.Increment(key, rule.Window)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the action triggered for ip. A non-nil error means the login must stop.
+func _EnforceStuffingPolicy(ip string, captchaSolved bool) error {
+	action, expiresAt, err := _CheckStuffing(ip)
+	if err != nil {
+		return _InternalError(err, utils.GENERIC_LOGIN_ERROR)
+	}
+	switch action {
+	case config.STUFFING_ACTION_BLOCK:
+		return _BanError(expiresAt)
+	case config.STUFFING_ACTION_CAPTCHA:
+		if !captchaSolved {
+			return ErrCaptchaRequired
+		}
+	case config.STUFFING_ACTION_DELAY:
+		time.Sleep(Stuffing.Delay)
+	}
+	return nil
+}
+
+func _StuffingCounters(ip string) map[string]StuffingRule {
+	counters := map[string]StuffingRule{"login_failures": Stuffing.Global}
+	if len(ip) > 0 {
+		counters["login_failures_ip:" + ip] = Stuffing.PerIP
+		counters["login_failures_subnet:" + utils.GetSubnet(ip)] = Stuffing.PerSubnet
+	}
+	return counters
+}
\ No newline at end of file
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"log/slog"
+	"context"
+	"strings"
+	"errors"
+	"math"
+	"fmt"
+	"io"
+	"os"
+)
+
+type contextKey string
+
+const loggerKey contextKey = "logger"
+
+// Points slog's default logger, and with it the standard log package, at the
+// configured output. Close the returned io.Closer on exit to flush the log file.
+func Setup(cfg config.LogConfig) (io.Closer, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(cfg.Level))
+	if err != nil {
+		return nil, err
+	}
+	var output io.WriteCloser = nopCloser{os.Stderr}
+	if len(cfg.File) > 0 {
+		output = &lumberjack.Logger{
+			Filename: cfg.File,
+			MaxSize: cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge: int(math.Ceil(cfg.MaxAge.Hours() / 24)),
+			Compress: cfg.Compress,
+		}
+	}
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: _RedactAttr}
+	var handler slog.Handler
+	switch cfg.Format {
+	case config.LOG_FORMAT_JSON:
+		handler = slog.NewJSONHandler(output, options)
+	case config.LOG_FORMAT_TEXT:
+		handler = slog.NewTextHandler(output, options)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown log format %q.", cfg.Format))
+	}
+	slog.SetDefault(slog.New(redactingHandler{handler}))
+	return output, nil
+}
+
+// Returns the request's logger, which tags every record with its request ID, or
+// the default logger outside requests.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// Redacts record messages, which ReplaceAttr doesn't see. Messages from the
+// standard log package often embed errors, and through them tokens.
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(attr)
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return redactingHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+func _RedactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if _IsSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, REDACTED)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		// errors and structs are flattened to strings so nothing nested slips through
+		return slog.String(attr.Key, Redact(fmt.Sprintf("%+v", attr.Value.Any())))
+	}
+	return attr
+}
+
+var keySeparators = strings.NewReplacer("_", "", "-", "")
+
+func _IsSensitiveKey(key string) bool {
+	key = keySeparators.Replace(strings.ToLower(key))
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, keySeparators.Replace(sensitive)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"regexp"
+)
+
+const REDACTED = "[REDACTED]"
+
+// Attributes whose key contains one of these are dropped whatever their value.
+// Keys are compared without case, underscores or dashes, so resetCode matches
+// reset_code. Codes are listed by kind, a bare "code" would hide error codes.
+var sensitiveKeys = []string{
+	"password", "token", "secret", "authorization", "cookie", "captcha",
+	"reset_code", "verification_code", "totp_code",
+}
+
+type redaction struct {
+	pattern *regexp.Regexp
+	replacement string
+}
+
+var redactions = []redaction{
+	// JWTs
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`), REDACTED},
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[^\s"',]+`), "$1 " + REDACTED},
+	// passwords in DSNs and URLs
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://[^:/@\s]+:)[^@\s]+@`), "${1}" + REDACTED + "@"},
+}
+
+// Masks anything in s that looks like a token or credential.
+func Redact(s string) string {
+	for _, r := range redactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
@@ -1,39 +1,163 @@
 package main
 
 import (
+	"github.com/shoppingapp/apiv1/config"
 	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/logging"
+	"github.com/shoppingapp/apiv1/metrics"
+	"github.com/shoppingapp/apiv1/middlewares"
 	"github.com/shoppingapp/apiv1/routes"
+	"github.com/shoppingapp/apiv1/servertls"
+	"github.com/shoppingapp/apiv1/utils"
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 	"log"
+	"log/slog"
 	"net/http"
+	"net"
+	"time"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Setting up environment variables
+	// Setting up environment variables, a missing .env is fine when settings come from elsewhere
 	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	cfg, err := config.LoadWithFlags(os.Args[0], os.Args[1:])
 	if err != nil {
-  	log.Fatal(err)
+		log.Fatal(err)
 	}
-	// Setting up logs
-	file, err := os.OpenFile("logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Setting up logs, the standard log package goes through them too
+	logFile, err := logging.Setup(cfg.Log)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.SetOutput(file)
 	// Setting up database
-	err = dbhelper.OpenDB()
+	err = dbhelper.OpenDB(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = dbhelper.CheckSchema()
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = dbhelper.InitDB()
+	err = dbhelper.OpenAttemptStore(cfg.AttemptStore)
 	if err != nil {
 		log.Fatal(err)
 	}
+	dbhelper.SetLockoutPolicies(cfg.Lockout)
+	dbhelper.SetStuffingPolicy(cfg.Stuffing)
+	dbhelper.SetPasswordResetConfig(cfg.PasswordReset)
+	dbhelper.SetAuditConfig(cfg.Audit)
+	metrics.SetSessionCounter(dbhelper.CountSessions)
+	utils.SetJWTConfig(cfg.JWT)
+	// Stopping on SIGTERM (sent by orchestrators) or SIGINT (Ctrl-C)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go dbhelper.RunAuditCheckpoints(ctx)
 	// Opening the webserver
 	r := mux.NewRouter()
 	r.StrictSlash(true)
-	routes.CreateRoutes(r)
-	http.ListenAndServe(":5005", r)
+	err = routes.CreateRoutes(r, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var handler http.Handler = middlewares.CORS(cfg.CORS, r)
+	serverTLS := cfg.Server.TLS
+	if serverTLS.Enabled() && serverTLS.HSTSMaxAge > 0 {
+		handler = middlewares.HSTS(serverTLS.HSTSMaxAge, serverTLS.HSTSIncludeSubdomains, handler)
+	}
+	server := _NewServer(cfg.Server, cfg.Server.Addr, middlewares.RequestID(middlewares.AccessLog(handler)))
+	servers := []*http.Server{server}
+	if serverTLS.Enabled() {
+		server.TLSConfig, err = servertls.NewConfig(serverTLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(serverTLS.RedirectAddr) > 0 {
+			redirect := _NewServer(cfg.Server, serverTLS.RedirectAddr, servertls.RedirectHandler(cfg.Server.Addr))
+			servers = append(servers, redirect)
+		}
+	}
+	if len(cfg.Server.MetricsAddr) > 0 {
+		metricsRouter := http.NewServeMux()
+		metricsRouter.Handle("/metrics", metrics.Handler())
+		servers = append(servers, _NewServer(cfg.Server, cfg.Server.MetricsAddr, metricsRouter))
+	}
+	err = _Serve(ctx, servers, cfg.Server.ShutdownTimeout)
+	// Cleaning up, os.Exit skips the deferred calls
+	dbhelper.CloseDB()
+	logFile.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func _NewServer(cfg config.ServerConfig, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr: addr,
+		Handler: handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout: cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout: cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// Serves until ctx is done or a server fails, then stops accepting connections
+// and waits up to shutdownTimeout for in-flight requests to finish. Servers with
+// a TLSConfig serve HTTPS.
+func _Serve(ctx context.Context, servers []*http.Server, shutdownTimeout time.Duration) error {
+	var listeners []net.Listener
+	for _, server := range servers {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			slog.Error("Couldn't listen", "addr", server.Addr, "err", err)
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	serveErr := make(chan error, len(servers))
+	for i, server := range servers {
+		server, listener := server, listeners[i]
+		go func() {
+			if server.TLSConfig != nil {
+				// the certificate comes from TLSConfig.GetCertificate
+				serveErr <- server.ServeTLS(listener, "", "")
+			} else {
+				serveErr <- server.Serve(listener)
+			}
+		}()
+		slog.Info("Server started", "addr", listener.Addr().String(), "tls", server.TLSConfig != nil)
+	}
+	var err error
+	select {
+	case err = <-serveErr:
+		slog.Error("Server failed", "err", err)
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down, draining in-flight requests", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		shutdownErr := server.Shutdown(shutdownCtx)
+		if shutdownErr != nil {
+			slog.Error("Requests were still in flight at the shutdown timeout", "addr", server.Addr, "err", shutdownErr)
+			err = shutdownErr
+		}
+	}
+	if err == nil {
+		slog.Info("Server stopped")
+	}
+	return err
 }
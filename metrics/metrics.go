@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"math"
+	"time"
+)
+
+const FLOW_LOGIN = "login"
+const FLOW_SIGNUP = "signup"
+const FLOW_RESET_REQUEST = "reset_request"
+const FLOW_RESET = "reset"
+const FLOW_REFRESH = "refresh"
+const FLOW_REVOKE = "revoke"
+
+// Why a flow failed, REASON_NONE when it succeeded.
+const REASON_NONE = ""
+const REASON_ERROR = "error"
+const REASON_WRONG_PASSWORD = "wrong_password"
+const REASON_UNKNOWN_EMAIL = "unknown_email"
+const REASON_LOCKED_OUT = "locked_out"
+const REASON_STUFFING_DEFENSE = "stuffing_defense"
+const REASON_EMAIL_TAKEN = "email_taken"
+const REASON_DISPLAY_NAME_TAKEN = "display_name_taken"
+const REASON_INVALID_CODE = "invalid_code"
+const REASON_INVALID_TOKEN = "invalid_token"
+
+var authOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "auth",
+	Name: "outcomes_total",
+	Help: "Login, signup, password reset and token refresh attempts by outcome and failure reason.",
+}, []string{"flow", "outcome", "reason"})
+
+var lockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "auth",
+	Name: "lockouts_total",
+	Help: "Bans imposed by each lockout policy.",
+}, []string{"policy"})
+
+var handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "auth",
+	Name: "http_request_duration_seconds",
+	Help: "Time taken to serve requests by route template, method and status code.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+var dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "auth",
+	Name: "db_operation_duration_seconds",
+	Help: "Time taken by each dbhelper operation.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+var bcryptDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "auth",
+	Name: "bcrypt_duration_seconds",
+	Help: "Time taken to hash or compare passwords.",
+	// bcrypt is slow on purpose, so the default buckets would lump everything together
+	Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"operation"})
+
+var countSessions func() (int64, error)
+
+var activeSessions = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: "auth",
+	Name: "active_sessions",
+	Help: "Refresh tokens currently issued.",
+}, func() float64 {
+	if countSessions == nil {
+		return math.NaN()
+	}
+	count, err := countSessions()
+	if err != nil {
+		slog.Error("Couldn't count sessions", "err", err)
+		return math.NaN()
+	}
+	return float64(count)
+})
+
+// Serves every metric in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Counts an attempt at flow, pass REASON_NONE when it succeeded.
+func AuthOutcome(flow, reason string) {
+	outcome := "success"
+	if reason != REASON_NONE {
+		outcome = "failure"
+	}
+	authOutcomes.WithLabelValues(flow, outcome, reason).Inc()
+}
+
+func Lockout(policy string) {
+	lockouts.WithLabelValues(policy).Inc()
+}
+
+func ObserveHandler(route, method string, status int, duration time.Duration) {
+	handlerDuration.WithLabelValues(route, method, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// Meant to be deferred at the top of the operation: defer metrics.ObserveDB("Name", time.Now())
+func ObserveDB(operation string, start time.Time) {
+	dbDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Meant to be deferred like ObserveDB.
+func ObserveBcrypt(operation string, start time.Time) {
+	bcryptDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Sets how the active sessions gauge is computed, it's reported as NaN until then.
+func SetSessionCounter(count func() (int64, error)) {
+	countSessions = count
+}
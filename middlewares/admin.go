@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/logging"
+	"github.com/shoppingapp/apiv1/utils"
+	"net/http"
+)
+
+// Like IsAccessTokenAuthorized, but the token's user must also be an admin. The
+// user is looked up by the ID in the token, never by display name, which can be
+// freed and claimed by someone else. The flag is read from the database on every
+// request so revoking it takes effect before the token expires.
+func IsAdminAuthorized(f http.HandlerFunc) http.HandlerFunc {
+	return IsAccessTokenAuthorized(func(w http.ResponseWriter, r *http.Request) {
+		user, err := dbhelper.GetUserByID(GetUserID(r))
+		if err != nil {
+			logging.FromContext(r.Context()).Info("Admin lookup failed", "err", err)
+			utils.WriteError(w, dbhelper.AsAuthError(err, utils.GENERIC_PROFILE_ERROR).APIError())
+			return
+		}
+		if !user.IsAdmin {
+			utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERROR_CODE_FORBIDDEN, utils.ADMIN_REQUIRED_ERROR))
+			return
+		}
+		f(w, r)
+	})
+}
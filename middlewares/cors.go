@@ -0,0 +1,164 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type corsPolicy struct {
+	prefix string
+	config config.CORSPolicyConfig
+	methods map[string]bool
+	headers map[string]bool
+}
+
+// Answers CORS preflight requests and adds CORS headers to the responses of
+// allowed origins, with the policy of the longest prefix in policies matching the
+// request's path. It wraps the router since preflight requests (OPTIONS) don't
+// match any route.
+func CORS(policies map[string]config.CORSPolicyConfig, next http.Handler) http.Handler {
+	var compiled []corsPolicy
+	for prefix, policy := range policies {
+		if len(policy.AllowedOrigins) == 0 {
+			// leaves preflight requests to the router, which refuses them
+			continue
+		}
+		compiled = append(compiled, corsPolicy{
+			prefix: prefix,
+			config: policy,
+			methods: _UpperSet(policy.AllowedMethods),
+			headers: _LowerSet(policy.AllowedHeaders),
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, ok := _MatchCORSPolicy(compiled, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// responses differ by origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0
+		allowed := len(origin) > 0 && policy._AllowsOrigin(origin)
+		if !preflight {
+			if allowed {
+				policy._SetOriginHeaders(w, origin)
+				if len(policy.config.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.config.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		// without the allow headers the browser refuses the actual request
+		if allowed && policy._AllowsPreflight(r) {
+			policy._SetOriginHeaders(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.config.AllowedMethods, ", "))
+			if len(policy.config.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.config.AllowedHeaders, ", "))
+			}
+			if policy.config.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.config.MaxAge.Seconds())))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (p corsPolicy) _AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.config.AllowedOrigins {
+		if _OriginMatches(strings.ToLower(pattern), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p corsPolicy) _AllowsPreflight(r *http.Request) bool {
+	if !p.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+		return false
+	}
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if len(header) > 0 && !p.headers[header] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p corsPolicy) _SetOriginHeaders(w http.ResponseWriter, origin string) {
+	if p.config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else if _Contains(p.config.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// Matches whole path segments, so /api/auth doesn't match /api/authz.
+func _MatchCORSPolicy(policies []corsPolicy, path string) (corsPolicy, bool) {
+	var match corsPolicy
+	found := false
+	for _, policy := range policies {
+		prefix := strings.TrimSuffix(policy.prefix, "/")
+		if path != prefix && !strings.HasPrefix(path, prefix + "/") {
+			continue
+		}
+		if !found || len(policy.prefix) > len(match.prefix) {
+			match, found = policy, true
+		}
+	}
+	return match, found
+}
+
+// "https://*.example.com" matches the origin of any subdomain of example.com, but
+// not https://example.com itself.
+func _OriginMatches(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.HasPrefix(host, "*.") {
+		return false
+	}
+	prefix, suffix := scheme + "://", host[1:]
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	subdomain := origin[len(prefix):len(origin) - len(suffix)]
+	return len(subdomain) > 0 && !strings.ContainsAny(subdomain, ":/")
+}
+
+func _Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func _UpperSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[strings.ToUpper(value)] = true
+	}
+	return set
+}
+
+func _LowerSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[strings.ToLower(value)] = true
+	}
+	return set
+}
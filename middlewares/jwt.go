@@ -1,13 +1,18 @@
 package middlewares
 
 import (
+	"github.com/shoppingapp/apiv1/logging"
 	"github.com/shoppingapp/apiv1/utils"
 	"net/http"
+	"context"
 	"strings"
 	"errors"
-	"log"
 )
 
+type contextKey string
+
+const userIDKey contextKey = "userID"
+
 func GetTokenFromAuthorizationHeader(authHeader string) (string, error) {
 	if len(authHeader) == 0 {
 		return "", errors.New(utils.MISSING_REQUEST_DATA)
@@ -24,16 +29,40 @@ func IsAccessTokenAuthorized(f http.HandlerFunc) http.HandlerFunc {
 		authorization := r.Header.Get("authorization")
 		accessTokenString, err := GetTokenFromAuthorizationHeader(authorization)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, utils.NewAPIError(http.StatusUnauthorized, utils.ERROR_CODE_INVALID_TOKEN, err.Error()))
 			return
 		}
-		_, err, errMessage := utils.VerifyJWTToken(utils.ACCESS_TYPE, accessTokenString)
+		claims, err, errMessage := utils.VerifyJWTToken(utils.ACCESS_TYPE, accessTokenString)
 		if err != nil {
 			// in FE, use the refresh token to get a new access token now
-			log.Println(err)
-			http.Error(w, errMessage, http.StatusBadRequest)
+			logging.FromContext(r.Context()).Info("Access token rejected", "err", err)
+			utils.WriteError(w, TokenError(err, errMessage))
 			return
 		}
-		f(w, r)
+		userID, ok := utils.GetUserID(claims)
+		if !ok {
+			utils.WriteError(w, utils.NewAPIError(http.StatusUnauthorized, utils.ERROR_CODE_INVALID_TOKEN, utils.JWT_TOKEN_PARSING_ERROR))
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		f(w, r.WithContext(ctx))
 	}
+}
+
+// Maps an error from utils.VerifyJWTToken to its response.
+func TokenError(err error, errMessage string) utils.APIError {
+	switch errMessage {
+	case utils.JWT_TOKEN_EXPIRED_ERROR:
+		return utils.NewAPIError(http.StatusUnauthorized, utils.ERROR_CODE_TOKEN_EXPIRED, errMessage)
+	case utils.JWT_TOKEN_PARSING_ERROR:
+		return utils.NewAPIError(http.StatusUnauthorized, utils.ERROR_CODE_INVALID_TOKEN, errMessage)
+	}
+	// the secrets couldn't be read
+	return utils.NewAPIError(http.StatusInternalServerError, utils.ERROR_CODE_INTERNAL, errMessage)
+}
+
+// Only valid inside handlers wrapped by IsAccessTokenAuthorized.
+func GetUserID(r *http.Request) uint {
+	userID, _ := r.Context().Value(userIDKey).(uint)
+	return userID
 }
\ No newline at end of file
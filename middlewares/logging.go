@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/logging"
+	"encoding/hex"
+	"crypto/rand"
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+const requestIDKey contextKey = "requestID"
+
+// IDs from upstream proxies are kept when they look like IDs, anything else could
+// be used to forge log lines.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Tags the request with the X-Request-ID it came with, or a new one, echoes it in
+// the response and stores a logger carrying it in the request context.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(requestID) {
+			requestID = _NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		ctx = logging.NewContext(ctx, slog.Default().With("request_id", requestID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Only valid inside handlers wrapped by RequestID.
+func GetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	return requestID
+}
+
+// Logs every request once it's served. Only the path is logged since query
+// strings may carry secrets.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(r.Context()).Log(r.Context(), level, "Request served",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"ip", GetClientIP(r),
+			"user_agent", r.UserAgent(),
+		)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Lets handlers reach the underlying writer's optional interfaces, like http.Flusher.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func _NewRequestID() string {
+	const REQUEST_ID_BYTES = 16
+	bytes := make([]byte, REQUEST_ID_BYTES)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
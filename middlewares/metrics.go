@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/metrics"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+// Times each request by the template of the route it matched, so paths with IDs
+// in them don't each get their own series. Add it with Router.Use, it needs the
+// matched route.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.ObserveHandler(route, r.Method, recorder.status, time.Since(start))
+	})
+}
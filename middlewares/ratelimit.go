@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/libstring"
+	"github.com/didip/tollbooth/v6/limiter"
+	"net/http"
+	"strconv"
+	"strings"
+	"math"
+	"time"
+)
+
+// Number of reverse proxies in front of the server. With 0 the client IP is the
+// socket address, otherwise it is taken from X-Forwarded-For, counting from the right,
+// so clients can't dodge limits by sending their own header. A request with fewer
+// entries than that didn't come through all the proxies, so its socket address is
+// used. X-Real-IP is never read, any client can set it.
+var trustedProxyHops int
+
+func SetTrustedProxyHops(hops int) {
+	trustedProxyHops = hops
+}
+
+func GetClientIP(r *http.Request) string {
+	remoteIP := libstring.RemoteIP([]string{"RemoteAddr"}, 0, r)
+	if trustedProxyHops <= 0 {
+		return remoteIP
+	}
+	// proxies may append to the header or send another one
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	if len(forwardedFor) < trustedProxyHops {
+		return remoteIP
+	}
+	ip := strings.TrimSpace(forwardedFor[len(forwardedFor) - trustedProxyHops])
+	if len(ip) == 0 {
+		return remoteIP
+	}
+	return ip
+}
+
+// Builds one limiter for the given limit. Routes that should share a budget wrap
+// their handlers with the same returned function.
+func RateLimiter(limit config.Limit) func(http.HandlerFunc) http.HandlerFunc {
+	lmt := tollbooth.NewLimiter(float64(limit.Count)/limit.Per.Seconds(), &limiter.ExpirableOptions{
+		DefaultExpirationTTL: limit.Per + time.Hour,
+	})
+	lmt.SetBurst(limit.Count)
+	// seconds until the bucket refills by one request
+	retryAfter := strconv.Itoa(int(math.Ceil(limit.Per.Seconds() / float64(limit.Count))))
+	return func(f http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ip := GetClientIP(r)
+			if len(ip) > 0 && tollbooth.LimitByKeys(lmt, []string{libstring.CanonicalizeIP(ip)}) != nil {
+				w.Header().Set("Retry-After", retryAfter)
+				utils.WriteError(w, utils.NewAPIError(http.StatusTooManyRequests, utils.ERROR_CODE_RATE_LIMITED, utils.TOO_MANY_REQUESTS_ERROR))
+				return
+			}
+			f(w, r)
+		}
+	}
+}
+
+func IsRateLimited(limit config.Limit, f http.HandlerFunc) http.HandlerFunc {
+	return RateLimiter(limit)(f)
+}
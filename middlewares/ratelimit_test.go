@@ -0,0 +1,40 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetClientIP(t *testing.T) {
+	defer SetTrustedProxyHops(0)
+	tests := []struct {
+		hops int
+		forwardedFor []string
+		realIP string
+		want string
+	}{
+		{0, []string{"198.51.100.7"}, "", "192.0.2.1"},
+		{1, []string{"198.51.100.7"}, "", "198.51.100.7"},
+		// the client's own entries are on the left
+		{1, []string{"203.0.113.9, 198.51.100.7"}, "", "198.51.100.7"},
+		{2, []string{"203.0.113.9, 198.51.100.7", "10.0.0.2"}, "", "198.51.100.7"},
+		// too few entries, the request skipped a proxy
+		{2, []string{"203.0.113.9"}, "", "192.0.2.1"},
+		{1, nil, "203.0.113.9", "192.0.2.1"},
+		{1, []string{""}, "", "192.0.2.1"},
+	}
+	for _, test := range tests {
+		SetTrustedProxyHops(test.hops)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		for _, value := range test.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if len(test.realIP) > 0 {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := GetClientIP(r); ip != test.want {
+			t.Errorf("with %d hops, X-Forwarded-For %q and X-Real-IP %q got %s, want %s", test.hops, test.forwardedFor, test.realIP, ip, test.want)
+		}
+	}
+}
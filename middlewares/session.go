@@ -0,0 +1,90 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/utils"
+	"net/http"
+	"time"
+)
+
+var sessionConfig = config.Default().Session
+var refreshCookiePath string
+var refreshCookieMaxAge time.Duration
+
+// refreshPath is the only path browsers send the refresh token cookie to.
+func SetSessionConfig(cfg config.SessionConfig, refreshPath string, refreshTokenDuration time.Duration) {
+	sessionConfig = cfg
+	refreshCookiePath = refreshPath
+	refreshCookieMaxAge = refreshTokenDuration
+}
+
+func CookieSessions() bool {
+	return sessionConfig.Mode == config.SESSION_MODE_COOKIE
+}
+
+// Sets the HttpOnly refresh token cookie, out of reach of scripts.
+func SetRefreshCookie(w http.ResponseWriter, refreshToken string) {
+	http.SetCookie(w, _SessionCookie(sessionConfig.CookieName, refreshToken, refreshCookiePath, true))
+}
+
+// Sets the CSRF token cookie, which scripts on the site read to echo the token in
+// the CSRF header.
+func SetCSRFCookie(w http.ResponseWriter, csrfToken string) {
+	http.SetCookie(w, _SessionCookie(sessionConfig.CSRFCookieName, csrfToken, "/", false))
+}
+
+// Expires the refresh token and CSRF cookies, so the browser drops them.
+func ClearSessionCookies(w http.ResponseWriter) {
+	cookies := []*http.Cookie{
+		_SessionCookie(sessionConfig.CookieName, "", refreshCookiePath, true),
+		_SessionCookie(sessionConfig.CSRFCookieName, "", "/", false),
+	}
+	for _, cookie := range cookies {
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+func GetRefreshCookie(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(sessionConfig.CookieName)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// With cookie sessions, requires the CSRF header to carry the CSRF token of the
+// session sessionID, whose refresh token came in the cookie. Otherwise it answers
+// 403 and returns false. The token is derived from the session ID in the HttpOnly
+// refresh cookie, so other sites can't read it, and can't swap it for their own by
+// setting the CSRF cookie either, since that cookie's value isn't trusted.
+func CheckCSRF(w http.ResponseWriter, r *http.Request, sessionID string) bool {
+	if !CookieSessions() {
+		return true
+	}
+	if !utils.CheckCSRFToken(sessionID, r.Header.Get(sessionConfig.CSRFHeaderName)) {
+		utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERROR_CODE_CSRF_FAILED, utils.CSRF_FAILED_ERROR))
+		return false
+	}
+	return true
+}
+
+func _SessionCookie(name, value, path string, httpOnly bool) *http.Cookie {
+	sameSite := http.SameSiteStrictMode
+	switch sessionConfig.SameSite {
+	case config.SAME_SITE_LAX:
+		sameSite = http.SameSiteLaxMode
+	case config.SAME_SITE_NONE:
+		sameSite = http.SameSiteNoneMode
+	}
+	return &http.Cookie{
+		Name: name,
+		Value: value,
+		Path: path,
+		Domain: sessionConfig.CookieDomain,
+		MaxAge: int(refreshCookieMaxAge.Seconds()),
+		Secure: sessionConfig.Secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
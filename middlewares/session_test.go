@@ -0,0 +1,195 @@
+package middlewares_test
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/routes"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const refreshPath = routes.AUTH_PREFIX + routes.REFRESH_JWT_TOKEN_PATH
+
+// Builds the routes with cookie sessions on a fresh memory database, the way main
+// does.
+func _NewCookieSessionRouter(t *testing.T) (*mux.Router, config.SessionConfig) {
+	cfg := config.Default()
+	cfg.Database.Driver = config.DATABASE_DRIVER_MEMORY
+	cfg.AttemptStore.Backend = config.ATTEMPT_STORE_MEMORY
+	cfg.Session.Mode = config.SESSION_MODE_COOKIE
+	for _, secret := range []*string{&cfg.JWT.AccessSecret, &cfg.JWT.RefreshSecret} {
+		var err error
+		*secret, err = utils.GenerateJWTSecret()
+		if err != nil {
+			t.Fatalf("GenerateJWTSecret: %v", err)
+		}
+	}
+	err := dbhelper.OpenDB(cfg.Database)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	err = dbhelper.OpenAttemptStore(cfg.AttemptStore)
+	if err != nil {
+		t.Fatalf("OpenAttemptStore: %v", err)
+	}
+	dbhelper.SetLockoutPolicies(cfg.Lockout)
+	dbhelper.SetStuffingPolicy(cfg.Stuffing)
+	dbhelper.SetPasswordResetConfig(cfg.PasswordReset)
+	dbhelper.SetAuditConfig(cfg.Audit)
+	utils.SetJWTConfig(cfg.JWT)
+	r := mux.NewRouter()
+	err = routes.CreateRoutes(r, &cfg)
+	if err != nil {
+		t.Fatalf("CreateRoutes: %v", err)
+	}
+	return r, cfg.Session
+}
+
+// Signs a user up, returning the response's cookies by name and its CSRF token.
+func _SignUp(t *testing.T, r http.Handler) (map[string]*http.Cookie, string) {
+	body := `{"Email": "ada@example.com", "DisplayName": "ada_lovelace",` +
+		` "Password": "correct horse battery", "ConfirmPassword": "correct horse battery"}`
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", routes.AUTH_PREFIX + "/signup", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signup got status %d with body %s", rec.Code, rec.Body.String())
+	}
+	var response routes.TokenResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if len(response.RefreshToken) > 0 || len(response.CSRFToken) == 0 {
+		t.Fatalf("signup returned %+v, want a CSRF token and no refresh token", response)
+	}
+	return _Cookies(rec), response.CSRFToken
+}
+
+func _Cookies(rec *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range rec.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	return cookies
+}
+
+// Sends the refresh token cookie to the refresh path with body, and csrfToken in
+// the CSRF header when it's set.
+func _RefreshRequest(r http.Handler, method string, refreshCookie *http.Cookie, csrfToken, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, refreshPath, strings.NewReader(body))
+	if refreshCookie != nil {
+		req.AddCookie(&http.Cookie{Name: refreshCookie.Name, Value: refreshCookie.Value})
+	}
+	if len(csrfToken) > 0 {
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func _ExpectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var body utils.ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != status || body.Error.Code != code {
+		t.Errorf("got status %d with body %s, want %d %s", rec.Code, rec.Body.String(), status, code)
+	}
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	r, session := _NewCookieSessionRouter(t)
+	cookies, csrfToken := _SignUp(t, r)
+
+	refresh := cookies[session.CookieName]
+	if refresh == nil {
+		t.Fatalf("signup didn't set the %s cookie", session.CookieName)
+	}
+	if !refresh.HttpOnly || !refresh.Secure || refresh.SameSite != http.SameSiteStrictMode || refresh.Path != refreshPath {
+		t.Errorf("refresh cookie is %+v, want HttpOnly, Secure, SameSite=Strict and Path=%s", refresh, refreshPath)
+	}
+	if refresh.MaxAge <= 0 {
+		t.Errorf("refresh cookie has Max-Age %d, want it to last as long as the refresh token", refresh.MaxAge)
+	}
+	csrf := cookies[session.CSRFCookieName]
+	if csrf == nil {
+		t.Fatalf("signup didn't set the %s cookie", session.CSRFCookieName)
+	}
+	// scripts read it to echo it in the header
+	if csrf.HttpOnly || !csrf.Secure || csrf.SameSite != http.SameSiteStrictMode || csrf.Path != "/" {
+		t.Errorf("CSRF cookie is %+v, want readable by scripts, Secure, SameSite=Strict and Path=/", csrf)
+	}
+	if csrf.Value != csrfToken {
+		t.Errorf("CSRF cookie is %q, want the response's token %q", csrf.Value, csrfToken)
+	}
+}
+
+func TestRefreshNeedsTheSessionsCSRFToken(t *testing.T) {
+	r, session := _NewCookieSessionRouter(t)
+	cookies, csrfToken := _SignUp(t, r)
+	refresh := cookies[session.CookieName]
+
+	_ExpectError(t, _RefreshRequest(r, "POST", refresh, "", ""), http.StatusForbidden, utils.ERROR_CODE_CSRF_FAILED)
+	_ExpectError(t, _RefreshRequest(r, "POST", refresh, csrfToken + "x", ""), http.StatusForbidden, utils.ERROR_CODE_CSRF_FAILED)
+
+	// a sibling subdomain can set the CSRF cookie, but the header has to match the
+	// session, not the cookie
+	req := httptest.NewRequest("POST", refreshPath, nil)
+	req.AddCookie(&http.Cookie{Name: refresh.Name, Value: refresh.Value})
+	req.AddCookie(&http.Cookie{Name: session.CSRFCookieName, Value: "attacker-chosen"})
+	req.Header.Set("X-CSRF-Token", "attacker-chosen")
+	tossed := httptest.NewRecorder()
+	r.ServeHTTP(tossed, req)
+	_ExpectError(t, tossed, http.StatusForbidden, utils.ERROR_CODE_CSRF_FAILED)
+
+	rec := _RefreshRequest(r, "POST", refresh, csrfToken, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh with the CSRF token got status %d with body %s", rec.Code, rec.Body.String())
+	}
+	// the session keeps its CSRF token across refreshes
+	var response routes.TokenResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if response.CSRFToken != csrfToken {
+		t.Errorf("refresh returned CSRF token %q, want the session's %q", response.CSRFToken, csrfToken)
+	}
+}
+
+func TestRefreshReadsTheCookieNotTheBody(t *testing.T) {
+	r, session := _NewCookieSessionRouter(t)
+	cookies, csrfToken := _SignUp(t, r)
+	refresh := cookies[session.CookieName]
+	body := `{"TokenString": "` + refresh.Value + `"}`
+
+	_ExpectError(t, _RefreshRequest(r, "POST", nil, csrfToken, body), http.StatusUnauthorized, utils.ERROR_CODE_INVALID_TOKEN)
+
+	rec := _RefreshRequest(r, "POST", refresh, csrfToken, `{"TokenString": "not a token"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("refresh with the cookie and a bogus body got status %d with body %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRevokeExpiresTheSessionCookies(t *testing.T) {
+	r, session := _NewCookieSessionRouter(t)
+	cookies, csrfToken := _SignUp(t, r)
+	refresh := cookies[session.CookieName]
+
+	_ExpectError(t, _RefreshRequest(r, "DELETE", refresh, "", ""), http.StatusForbidden, utils.ERROR_CODE_CSRF_FAILED)
+
+	rec := _RefreshRequest(r, "DELETE", refresh, csrfToken, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign-out got status %d with body %s", rec.Code, rec.Body.String())
+	}
+	cleared := _Cookies(rec)
+	for _, name := range []string{session.CookieName, session.CSRFCookieName} {
+		cookie := cleared[name]
+		if cookie == nil || cookie.MaxAge >= 0 || len(cookie.Value) > 0 {
+			t.Errorf("sign-out set %s to %+v, want it expired", name, cookie)
+		}
+	}
+	if cookie := cleared[session.CookieName]; cookie != nil && cookie.Path != refreshPath {
+		t.Errorf("sign-out expired the refresh cookie at %s, the browser keeps the one at %s", cookie.Path, refreshPath)
+	}
+	_ExpectError(t, _RefreshRequest(r, "POST", refresh, csrfToken, ""), http.StatusUnauthorized, utils.ERROR_CODE_INVALID_TOKEN)
+}
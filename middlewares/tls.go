@@ -0,0 +1,33 @@
+package middlewares
+
+import (
+	"github.com/shoppingapp/apiv1/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Tells browsers to only ever reach the server over HTTPS for maxAge. Only add
+// it to the HTTPS server, browsers ignore the header over plain HTTP anyway.
+func HSTS(maxAge time.Duration, includeSubdomains bool, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Lets through only requests whose connection presented a client certificate
+// that the TLS handshake verified against server.tls.clientCAFile.
+func IsClientCertAuthorized(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERROR_CODE_FORBIDDEN, utils.CLIENT_CERT_REQUIRED_ERROR))
+			return
+		}
+		f(w, r)
+	}
+}
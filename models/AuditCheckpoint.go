@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// A signed copy of the audit log's chain head. Rewriting the events up to Seq
+// changes their hashes, so it can't go unnoticed without the private signing key.
+// Checkpoints are chained too: Number counts them from 1 and PrevHash is the hash
+// of the checkpoint before, so deleting one breaks the chain.
+type AuditCheckpoint struct {
+	ID uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Number uint `gorm:"uniqueIndex"`
+	Seq uint `gorm:"index"`
+	Hash string `gorm:"size:64"`
+	PrevHash string `gorm:"size:64"`
+	// base64 Ed25519 signature of the fields above
+	Signature string `gorm:"size:128"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+const AUTH_EVENT_SIGNUP = "signup"
+const AUTH_EVENT_LOGIN_SUCCESS = "login_success"
+const AUTH_EVENT_LOGIN_FAILURE = "login_failure"
+const AUTH_EVENT_LOCKOUT = "lockout"
+const AUTH_EVENT_RESET_REQUESTED = "reset_requested"
+const AUTH_EVENT_RESET_COMPLETED = "reset_completed"
+const AUTH_EVENT_TOKEN_ROTATED = "token_rotated"
+const AUTH_EVENT_SESSION_REVOKED = "session_revoked"
+const AUTH_EVENT_ADMIN_CHANGED = "admin_changed"
+
+var AUTH_EVENT_TYPES = []string{
+	AUTH_EVENT_SIGNUP,
+	AUTH_EVENT_LOGIN_SUCCESS,
+	AUTH_EVENT_LOGIN_FAILURE,
+	AUTH_EVENT_LOCKOUT,
+	AUTH_EVENT_RESET_REQUESTED,
+	AUTH_EVENT_RESET_COMPLETED,
+	AUTH_EVENT_TOKEN_ROTATED,
+	AUTH_EVENT_SESSION_REVOKED,
+	AUTH_EVENT_ADMIN_CHANGED,
+}
+
+// An entry of the security audit log. Events are never updated or deleted, so
+// unlike the other models this one has no gorm.Model.
+type AuthEvent struct {
+	ID uint `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Type string `gorm:"size:32;index"`
+	// nil when the email doesn't belong to an account
+	UserID *uint `gorm:"index"`
+	Email string `gorm:"size:255;index"`
+	IP string `gorm:"size:45"`
+	UserAgent string `gorm:"size:512"`
+	// e.g. which lockout was triggered
+	Detail string `gorm:"size:255"`
+	// position in the audit log's hash chain, starting at 1
+	Seq uint `gorm:"uniqueIndex"`
+	// hex SHA-256 over the previous event's Hash and this event, see storage.HashEvent
+	Hash string `gorm:"size:64"`
+}
@@ -10,23 +10,11 @@ type User struct {
 	Email string `gorm:"unique"`
 	PasswordHash string
 	DisplayName string `gorm:"unique"`
+	EmailVerified bool
 	PhoneVerified bool
-}
-
-type LoginAttempts struct {
-	gorm.Model
-	Email string `gorm:"unique"`
-	NumAttempts uint
-	BanExpiresAt time.Time
-}
-
-type PasswordResetAttempts struct {
-	gorm.Model
-	Email string `gorm:"unique"`
-	NumRequests uint
-	RequestsBanExpiresAt time.Time
-	NumAttempts uint
-	AttemptsBanExpiresAt time.Time
+	TwoFactorEnabled bool
+	// may query the audit log, granted with authctl set-admin
+	IsAdmin bool
 }
 
 type PasswordResetCode struct {
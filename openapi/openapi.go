@@ -0,0 +1,489 @@
+package openapi
+
+import (
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"encoding/json"
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"errors"
+	"sort"
+	"time"
+	"fmt"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+const CONTENT_TYPE_JSON = "application/json"
+const BEARER_AUTH = "bearerAuth"
+
+// Documents one route. Request and Response are zero values of the body types,
+// nil when there is no body, Responses adds other statuses that aren't errors and
+// Errors lists the utils.ERROR_CODE_* codes the route can fail with.
+type Operation struct {
+	Method string
+	Path string
+	Summary string
+	Tag string
+	Authenticated bool
+	Query []Parameter
+	Request interface{}
+	Response interface{}
+	// defaults to application/json
+	ResponseType string
+	Responses map[int]interface{}
+	Errors []string
+}
+
+type Info struct {
+	Title string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version string `json:"version"`
+}
+
+type Document struct {
+	OpenAPI string `json:"openapi"`
+	Info Info `json:"info"`
+	Paths map[string]map[string]*PathOperation `json:"paths"`
+	Components Components `json:"components"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type string `json:"type"`
+	Scheme string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+type PathOperation struct {
+	Summary string `json:"summary,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	Parameters []Parameter `json:"parameters,omitempty"`
+	RequestBody *RequestBody `json:"requestBody,omitempty"`
+	Responses map[string]*Response `json:"responses"`
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name string `json:"name"`
+	In string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required bool `json:"required,omitempty"`
+	Schema *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool `json:"required"`
+	Content map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string `json:"description"`
+	Headers map[string]Header `json:"headers,omitempty"`
+	Content map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string `json:"description,omitempty"`
+	Schema *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref string `json:"$ref,omitempty"`
+	Type string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	Nullable bool `json:"nullable,omitempty"`
+	Enum []string `json:"enum,omitempty"`
+	MinLength *int `json:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty"`
+	Minimum *float64 `json:"minimum,omitempty"`
+	Maximum *float64 `json:"maximum,omitempty"`
+	Items *Schema `json:"items,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+	Required []string `json:"required,omitempty"`
+}
+
+// Builds the document for operations. Body types become component schemas named
+// after their Go types, with the constraints in their validate tags.
+func Generate(info Info, operations []Operation) (*Document, error) {
+	doc := &Document{
+		OpenAPI: OPENAPI_VERSION,
+		Info: info,
+		Paths: map[string]map[string]*PathOperation{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				BEARER_AUTH: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+	for _, operation := range operations {
+		path, parameters := _PathParameters(operation.Path)
+		method := strings.ToLower(operation.Method)
+		if _, ok := doc.Paths[path][method]; ok {
+			return nil, errors.New(fmt.Sprintf("%s %s is documented twice.", operation.Method, operation.Path))
+		}
+		pathOperation := &PathOperation{
+			Summary: operation.Summary,
+			Parameters: append(parameters, operation.Query...),
+			Responses: map[string]*Response{},
+		}
+		if len(operation.Tag) > 0 {
+			pathOperation.Tags = []string{operation.Tag}
+		}
+		if operation.Authenticated {
+			pathOperation.Security = []map[string][]string{{BEARER_AUTH: {}}}
+		}
+		if operation.Request != nil {
+			pathOperation.RequestBody = &RequestBody{
+				Required: true,
+				Content: map[string]MediaType{CONTENT_TYPE_JSON: {Schema: doc._Schema(reflect.TypeOf(operation.Request))}},
+			}
+		}
+		pathOperation.Responses["200"] = doc._Response("OK", operation.ResponseType, operation.Response)
+		for status, body := range operation.Responses {
+			pathOperation.Responses[strconv.Itoa(status)] = doc._Response(http.StatusText(status), "", body)
+		}
+		doc._ErrorResponses(pathOperation, operation.Errors)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*PathOperation{}
+		}
+		doc.Paths[path][method] = pathOperation
+	}
+	return doc, nil
+}
+
+// Lists how the routes registered on r and operations differ, returning nil when
+// every route is documented and every documented route exists. When published is
+// set it must be a document clients were given (JSON), and what operations
+// generate is compared with it too: each operation's parameters, request body,
+// response bodies, statuses and error codes, and the schemas they use.
+func Check(r *mux.Router, operations []Operation, published []byte) error {
+	problems, err := _CheckRoutes(r, operations)
+	if err != nil {
+		return err
+	}
+	if published != nil {
+		documentProblems, err := _CheckDocument(operations, published)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, documentProblems...)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(fmt.Sprintf("The OpenAPI document is out of date: %s.", strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+func _CheckRoutes(r *mux.Router, operations []Operation) ([]string, error) {
+	routed := map[string]bool{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		// subrouter prefixes match every method
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routed[method + " " + path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	documented := map[string]bool{}
+	var problems []string
+	for _, operation := range operations {
+		key := operation.Method + " " + operation.Path
+		documented[key] = true
+		if !routed[key] {
+			problems = append(problems, key + " is documented but not routed")
+		}
+	}
+	for key := range routed {
+		if !documented[key] {
+			problems = append(problems, key + " is routed but not documented")
+		}
+	}
+	return problems, nil
+}
+
+// Error codes are listed in the descriptions of the error responses, so comparing
+// whole responses catches a code that was added or dropped.
+func _CheckDocument(operations []Operation, published []byte) ([]string, error) {
+	doc, err := Generate(Info{}, operations)
+	if err != nil {
+		return nil, err
+	}
+	var publishedDoc Document
+	err = json.Unmarshal(published, &publishedDoc)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("The published OpenAPI document can't be read: %s.", err.Error()))
+	}
+	var problems []string
+	for path, methods := range doc.Paths {
+		for method, operation := range methods {
+			key := strings.ToUpper(method) + " " + path
+			publishedOperation, ok := publishedDoc.Paths[path][method]
+			if !ok {
+				problems = append(problems, key + " isn't published")
+				continue
+			}
+			if !_SameJSON(operation.Parameters, publishedOperation.Parameters) {
+				problems = append(problems, key + " has different parameters")
+			}
+			if !_SameJSON(operation.RequestBody, publishedOperation.RequestBody) {
+				problems = append(problems, key + " has a different request body")
+			}
+			for status, response := range operation.Responses {
+				publishedResponse, ok := publishedOperation.Responses[status]
+				if !ok {
+					problems = append(problems, key + " responds " + status + ", which isn't published")
+				} else if !_SameJSON(response, publishedResponse) {
+					problems = append(problems, key + " has a different " + status + " response")
+				}
+			}
+			for status := range publishedOperation.Responses {
+				if _, ok := operation.Responses[status]; !ok {
+					problems = append(problems, key + " no longer responds " + status)
+				}
+			}
+		}
+	}
+	for path, methods := range publishedDoc.Paths {
+		for method := range methods {
+			if _, ok := doc.Paths[path][method]; !ok {
+				problems = append(problems, strings.ToUpper(method) + " " + path + " is published but no longer documented")
+			}
+		}
+	}
+	for name, schema := range doc.Components.Schemas {
+		publishedSchema, ok := publishedDoc.Components.Schemas[name]
+		if !ok {
+			problems = append(problems, "the " + name + " schema isn't published")
+		} else if !_SameJSON(schema, publishedSchema) {
+			problems = append(problems, "the " + name + " schema is different")
+		}
+	}
+	for name := range publishedDoc.Components.Schemas {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			problems = append(problems, "the " + name + " schema is published but no longer used")
+		}
+	}
+	return problems, nil
+}
+
+func _SameJSON(a, b interface{}) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aJSON, bJSON)
+}
+
+func (doc *Document) _Response(description, contentType string, body interface{}) *Response {
+	response := &Response{Description: description}
+	if len(contentType) == 0 {
+		contentType = CONTENT_TYPE_JSON
+	}
+	if contentType != CONTENT_TYPE_JSON {
+		response.Content = map[string]MediaType{contentType: {Schema: &Schema{Type: "string"}}}
+	} else if body != nil {
+		response.Content = map[string]MediaType{contentType: {Schema: doc._Schema(reflect.TypeOf(body))}}
+	}
+	return response
+}
+
+// Groups error codes by the status they're sent with.
+func (doc *Document) _ErrorResponses(pathOperation *PathOperation, codes []string) {
+	errorSchema := doc._Schema(reflect.TypeOf(utils.ErrorResponse{}))
+	codesByStatus := map[int][]string{}
+	for _, code := range codes {
+		status := utils.ErrorStatus(code)
+		codesByStatus[status] = append(codesByStatus[status], "`" + code + "`")
+	}
+	for status, statusCodes := range codesByStatus {
+		description := fmt.Sprintf("%s, with the code %s.", http.StatusText(status), statusCodes[0])
+		if len(statusCodes) > 1 {
+			description = fmt.Sprintf("%s, with one of the codes %s.", http.StatusText(status), strings.Join(statusCodes, ", "))
+		}
+		response := &Response{
+			Description: description,
+			Content: map[string]MediaType{CONTENT_TYPE_JSON: {Schema: errorSchema}},
+		}
+		if status == http.StatusTooManyRequests {
+			response.Headers = map[string]Header{
+				"Retry-After": {Description: "Seconds until the request may be retried.", Schema: &Schema{Type: "integer"}},
+			}
+		}
+		pathOperation.Responses[strconv.Itoa(status)] = response
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (doc *Document) _Schema(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := doc._Schema(t.Elem())
+		if len(schema.Ref) == 0 {
+			schema.Nullable = true
+		}
+		return schema
+	case t.Kind() == reflect.Struct && len(t.Name()) > 0:
+		if _, ok := doc.Components.Schemas[t.Name()]; !ok {
+			// registered before its fields so recursive types terminate
+			doc.Components.Schemas[t.Name()] = &Schema{}
+			*doc.Components.Schemas[t.Name()] = *doc._ObjectSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Struct:
+		return doc._ObjectSchema(t)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &Schema{Type: "array", Items: doc._Schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc._Schema(t.Elem())}
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if t.Bits() == 64 {
+			return &Schema{Type: "integer", Format: "int64"}
+		}
+		return &Schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	return &Schema{}
+}
+
+// Fields are named by their json tag, or like the request body's JSON keys (e.g.
+// displayName) when they have none. They're required when their validate tag
+// says so, or when they're always written in a response.
+func (doc *Document) _ObjectSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, omitEmpty := _LowerCamel(field.Name), false
+		jsonTag, hasJSONTag := field.Tag.Lookup("json")
+		if hasJSONTag {
+			options := strings.Split(jsonTag, ",")
+			if options[0] == "-" {
+				continue
+			}
+			if len(options[0]) > 0 {
+				name = options[0]
+			}
+			omitEmpty = _Contains(options[1:], "omitempty")
+		}
+		property := doc._Schema(field.Type)
+		required := hasJSONTag && !omitEmpty
+		if validateTag := field.Tag.Get("validate"); len(validateTag) > 0 && len(property.Ref) == 0 {
+			required = _ApplyValidateTag(property, validateTag)
+		}
+		schema.Properties[name] = property
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// Adds the constraints in a validate tag to schema, returning whether the field is
+// required.
+func _ApplyValidateTag(schema *Schema, validateTag string) bool {
+	required := false
+	for _, rule := range strings.Split(validateTag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "url":
+			schema.Format = "uri"
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		case "eqfield":
+			schema.Description = fmt.Sprintf("Must equal %s.", _LowerCamel(param))
+		case "min", "max", "len":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			if schema.Type == "string" {
+				if name != "max" {
+					schema.MinLength = &n
+				}
+				if name != "min" {
+					schema.MaxLength = &n
+				}
+			} else if schema.Type == "integer" || schema.Type == "number" {
+				bound := float64(n)
+				if name != "max" {
+					schema.Minimum = &bound
+				}
+				if name != "min" {
+					schema.Maximum = &bound
+				}
+			}
+		}
+	}
+	return required
+}
+
+// Turns mux path variables like {id:[0-9]+} into OpenAPI ones like {id}.
+func _PathParameters(path string) (string, []Parameter) {
+	var parameters []Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name, _, _ := strings.Cut(segment[1:len(segment)-1], ":")
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+func _LowerCamel(name string) string {
+	runes := []rune(name)
+	if len(runes) == 0 {
+		return name
+	}
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func _Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
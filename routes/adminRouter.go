@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/middlewares"
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/storage"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"encoding/json"
+	"strconv"
+	"errors"
+	"time"
+	"fmt"
+)
+
+type AuthEventResponse struct {
+	ID uint `json:"id"`
+	Type string `json:"type"`
+	UserID *uint `json:"userId"`
+	Email string `json:"email"`
+	IP string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	Detail string `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AuthEventsResponse struct {
+	Events []AuthEventResponse `json:"events"`
+}
+
+func AdminRouter(s *mux.Router, limits config.RateLimitConfig) {
+	s.HandleFunc(
+		"/auth_events",
+		middlewares.IsRateLimited(limits.Admin, middlewares.IsAdminAuthorized(GetAuthEvents)),
+	).Methods("GET")
+}
+
+func NewAuthEventResponse(event models.AuthEvent) AuthEventResponse {
+	return AuthEventResponse{
+		ID: event.ID,
+		Type: event.Type,
+		UserID: event.UserID,
+		Email: event.Email,
+		IP: event.IP,
+		UserAgent: event.UserAgent,
+		Detail: event.Detail,
+		CreatedAt: event.CreatedAt,
+	}
+}
+
+// Lists audit events, newest first, filtered by the userId, email, type, from and
+// to (RFC 3339) query parameters. limit defaults to 100 and is capped at 1000.
+func GetAuthEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := ParseEventFilter(r)
+	if err != nil {
+		utils.WriteError(w, utils.NewAPIError(
+			http.StatusBadRequest,
+			utils.ERROR_CODE_INVALID_QUERY,
+			fmt.Sprintf("%s %v", utils.INVALID_AUDIT_QUERY_ERROR, err),
+		))
+		return
+	}
+	events, err := dbhelper.QueryAuthEvents(filter)
+	if err != nil {
+		GenericAuthError(w, r, err, utils.GENERIC_AUDIT_ERROR)
+		return
+	}
+	response := AuthEventsResponse{Events: []AuthEventResponse{}}
+	for _, event := range events {
+		response.Events = append(response.Events, NewAuthEventResponse(event))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func ParseEventFilter(r *http.Request) (storage.EventFilter, error) {
+	const DEFAULT_LIMIT = 100
+	const MAX_LIMIT = 1000
+	query := r.URL.Query()
+	filter := storage.EventFilter{
+		Email: query.Get("email"),
+		Type: query.Get("type"),
+		Limit: DEFAULT_LIMIT,
+	}
+	if len(filter.Type) > 0 && !_Contains(models.AUTH_EVENT_TYPES, filter.Type) {
+		return filter, errors.New(fmt.Sprintf("Unknown event type %q.", filter.Type))
+	}
+	if userID := query.Get("userId"); len(userID) > 0 {
+		id, err := strconv.ParseUint(userID, 10, 0)
+		if err != nil {
+			return filter, errors.New("userId must be a number.")
+		}
+		filter.UserID = uint(id)
+	}
+	var err error
+	for name, field := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); len(value) > 0 {
+			*field, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, errors.New(fmt.Sprintf("%s must be an RFC 3339 time.", name))
+			}
+		}
+	}
+	if limit := query.Get("limit"); len(limit) > 0 {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 || filter.Limit > MAX_LIMIT {
+			return filter, errors.New(fmt.Sprintf("limit must be between 1 and %d.", MAX_LIMIT))
+		}
+	}
+	return filter, nil
+}
+
+func _Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,31 @@
 package routes
 
 import (
+	"github.com/shoppingapp/apiv1/config"
 	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/logging"
+	"github.com/shoppingapp/apiv1/middlewares"
 	"github.com/shoppingapp/apiv1/utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"net/http"
 	"encoding/json"
-	"log"
+	"unicode"
+	"errors"
+	"io"
 )
 
+// With cookie sessions the refresh token is left out for its cookie, and
+// CSRFToken is the token to echo in the CSRF header.
 type TokenResponse struct {
 	AccessToken string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	CSRFToken string `json:"csrfToken,omitempty"`
 }
 
 type StatusResponse struct {
 	Status string `json:"status"`
+	Lockout *utils.LockoutDetails `json:"lockout,omitempty"`
 }
 
 type SignupAttempt struct {
@@ -23,15 +33,18 @@ type SignupAttempt struct {
 	DisplayName string `validate:"required,min=4,max=64"`
 	Password string `validate:"required,min=8,max=64,eqfield=ConfirmPassword"`
 	ConfirmPassword string `validate:"required,min=8,max=64"`
+	CaptchaToken string
 }
 
 type LoginAttempt struct {
 	Email string `validate:"required,email"`
 	Password string `validate:"required"`
+	CaptchaToken string
 }
 
 type PasswordResetRequest struct {
 	Email string `validate:"required,email"`
+	CaptchaToken string
 }
 
 type PasswordResetAttempt struct {
@@ -46,20 +59,125 @@ type RefreshTokenBody struct {
 }
 
 type RequestBody interface {
-	SignupAttempt | LoginAttempt | PasswordResetRequest | PasswordResetAttempt | RefreshTokenBody
+	SignupAttempt | LoginAttempt | PasswordResetRequest | PasswordResetAttempt | RefreshTokenBody | ProfileUpdate
 }
 
-func AuthRouter(s *mux.Router) {
-	s.HandleFunc("/login", Login).Methods("POST")
-	s.HandleFunc("/signup", Signup).Methods("POST")
-	s.HandleFunc("/request_password_reset", RequestPasswordReset).Methods("POST")
-	s.HandleFunc("/reset_password", ResetPassword).Methods("POST")
-	s.HandleFunc("/refresh_jwt_token", RefreshJWTToken).Methods("POST")
+const REFRESH_JWT_TOKEN_PATH = "/refresh_jwt_token"
+
+func AuthRouter(s *mux.Router, limits config.RateLimitConfig) {
+	s.HandleFunc("/login", middlewares.IsRateLimited(limits.Login, Login)).Methods("POST")
+	s.HandleFunc("/signup", middlewares.IsRateLimited(limits.Signup, Signup)).Methods("POST")
+	s.HandleFunc(
+		"/request_password_reset",
+		middlewares.IsRateLimited(limits.RequestPasswordReset, RequestPasswordReset),
+	).Methods("POST")
+	s.HandleFunc("/reset_password", middlewares.IsRateLimited(limits.ResetPassword, ResetPassword)).Methods("POST")
+	// refreshing and signing out share one budget
+	refreshLimit := middlewares.RateLimiter(limits.RefreshJWTToken)
+	s.HandleFunc(REFRESH_JWT_TOKEN_PATH, refreshLimit(RefreshJWTToken)).Methods("POST")
+	s.HandleFunc(REFRESH_JWT_TOKEN_PATH, refreshLimit(RevokeRefreshToken)).Methods("DELETE")
+}
+
+func GenericAuthError(w http.ResponseWriter, r *http.Request, err error, errorMessage string) {
+	logging.FromContext(r.Context()).Warn("Request failed", "err", err, "message", errorMessage)
+	utils.WriteError(w, ClassifyError(err, errorMessage))
+}
+
+// Picks the status and code of a failed request from its error. Errors that aren't
+// a dbhelper.AuthError are shown as errorMessage, with its status and code when
+// it's one of the token errors from utils.VerifyJWTToken.
+func ClassifyError(err error, errorMessage string) utils.APIError {
+	var validationErrors validator.ValidationErrors
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var authError *dbhelper.AuthError
+	switch {
+	case errors.As(err, &validationErrors):
+		apiErr := utils.NewAPIError(http.StatusUnprocessableEntity, utils.ERROR_CODE_VALIDATION_FAILED, utils.MISSING_REQUEST_DATA)
+		for _, fieldError := range validationErrors {
+			apiErr.Fields = append(apiErr.Fields, utils.FieldError{
+				Field: _LowerCamel(fieldError.Field()),
+				Rule: fieldError.Tag(),
+				Param: fieldError.Param(),
+			})
+		}
+		return apiErr
+	case errors.As(err, &syntaxError), errors.As(err, &typeError), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return utils.NewAPIError(http.StatusBadRequest, utils.ERROR_CODE_MALFORMED_REQUEST, utils.MISSING_REQUEST_DATA)
+	case errors.As(err, &authError):
+		// a captcha_required error tells the client to show a captcha and resend
+		// the request with its token
+		return authError.APIError()
+	}
+	switch errorMessage {
+	case utils.JWT_TOKEN_EXPIRED_ERROR, utils.JWT_TOKEN_PARSING_ERROR:
+		return middlewares.TokenError(err, errorMessage)
+	}
+	return utils.NewAPIError(http.StatusInternalServerError, utils.ERROR_CODE_INTERNAL, errorMessage)
+}
+
+// Field names in responses match the request body's JSON keys, e.g. displayName.
+func _LowerCamel(field string) string {
+	runes := []rune(field)
+	if len(runes) == 0 {
+		return field
+	}
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
 }
 
-func GenericAuthError(w http.ResponseWriter, err error, errorMessage string) {
-	log.Println(err)
-	http.Error(w, errorMessage, http.StatusBadRequest)
+// Checks the captcha of a request to an endpoint that may require one and returns
+// whether it was solved. If a captcha is required but wasn't solved, the response
+// is written and ok is false. The provider is only asked when a captcha is
+// required, so an outage doesn't fail requests that never needed one.
+func CheckCaptcha(
+	w http.ResponseWriter,
+	r *http.Request,
+	policy *dbhelper.LockoutPolicy,
+	threshold int,
+	id, token, errorMessage string,
+) (solved bool, ok bool) {
+	if captchaVerifier == nil {
+		return false, true
+	}
+	ip := middlewares.GetClientIP(r)
+	required, err := dbhelper.IsCaptchaRequired(policy, threshold, id, ip)
+	if err != nil {
+		GenericAuthError(w, r, err, errorMessage)
+		return false, false
+	}
+	if !required {
+		return false, true
+	}
+	if len(token) > 0 {
+		solved, err = captchaVerifier.Verify(token, ip)
+		if err != nil {
+			GenericAuthError(w, r, err, errorMessage)
+			return false, false
+		}
+	}
+	if !solved {
+		GenericAuthError(w, r, dbhelper.ErrCaptchaRequired, utils.CAPTCHA_REQUIRED_ERROR)
+		return false, false
+	}
+	return solved, true
+}
+
+func GetClientInfo(r *http.Request) dbhelper.ClientInfo {
+	return dbhelper.ClientInfo{IP: middlewares.GetClientIP(r), UserAgent: r.UserAgent()}
+}
+
+// Sends the tokens of a session. With cookie sessions the refresh token is set as
+// its cookie instead, next to the session's CSRF token.
+func WriteTokenResponse(w http.ResponseWriter, tokens dbhelper.SessionTokens) {
+	response := TokenResponse{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken}
+	if middlewares.CookieSessions() {
+		middlewares.SetRefreshCookie(w, tokens.RefreshToken)
+		middlewares.SetCSRFCookie(w, tokens.CSRFToken)
+		response.RefreshToken, response.CSRFToken = "", tokens.CSRFToken
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 func DecodeValidBody[B RequestBody](r *http.Request) (B, error) {
@@ -79,140 +197,197 @@ func DecodeValidBody[B RequestBody](r *http.Request) (B, error) {
 func Login(w http.ResponseWriter, r *http.Request) {
 	loginAttempt, err := DecodeValidBody[LoginAttempt](r)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_LOGIN_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_LOGIN_ERROR)
 		return
 	}
-	accessToken, refreshToken, err, errMessage := dbhelper.LoginUserWithPassword(
+	captchaSolved, ok := CheckCaptcha(
+		w, r,
+		&dbhelper.LoginLockout,
+		captchaConfig.LoginAfter,
+		loginAttempt.Email,
+		loginAttempt.CaptchaToken,
+		utils.GENERIC_LOGIN_ERROR,
+	)
+	if !ok {
+		return
+	}
+	tokens, err := dbhelper.LoginUserWithPassword(
 		loginAttempt.Email, 
 		loginAttempt.Password, 
+		GetClientInfo(r),
+		captchaSolved,
 	)
 	if err != nil {
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, utils.GENERIC_LOGIN_ERROR)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TokenResponse{
-		AccessToken: accessToken, 
-		RefreshToken: refreshToken,
-	})
+	WriteTokenResponse(w, tokens)
 }
 
 func Signup(w http.ResponseWriter, r *http.Request) {
 	signupAttempt, err := DecodeValidBody[SignupAttempt](r)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_LOGIN_ERROR)
-		return
-	}
-	accessToken, err := utils.CreateJWTToken(signupAttempt.DisplayName, "access")
-	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_SIGNUP_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_SIGNUP_ERROR)
 		return
 	}
-	refreshToken, err := utils.CreateJWTToken(signupAttempt.DisplayName, "refresh")
-	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_SIGNUP_ERROR)
+	_, ok := CheckCaptcha(w, r, nil, 0, "", signupAttempt.CaptchaToken, utils.GENERIC_SIGNUP_ERROR)
+	if !ok {
 		return
 	}
 	passwordHash, err := utils.HashPassword(signupAttempt.Password)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_SIGNUP_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_SIGNUP_ERROR)
 		return
 	}
-	err, errMessage := dbhelper.CreateUser(
+	tokens, err := dbhelper.CreateUser(
 		signupAttempt.Email, 
 		signupAttempt.DisplayName, 
 		passwordHash, 
-		refreshToken,
+		GetClientInfo(r),
 	)
 	if err != nil {
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, utils.GENERIC_SIGNUP_ERROR)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TokenResponse{
-		AccessToken: accessToken, 
-		RefreshToken: refreshToken,
-	})
+	WriteTokenResponse(w, tokens)
 }
 
 func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 	passwordResetRequest, err := DecodeValidBody[PasswordResetRequest](r)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 		return
 	}
-	err, errMessage := dbhelper.CreatePasswordResetCode(passwordResetRequest.Email)
+	_, ok := CheckCaptcha(
+		w, r,
+		&dbhelper.ResetRequestLockout,
+		captchaConfig.ResetRequestAfter,
+		passwordResetRequest.Email,
+		passwordResetRequest.CaptchaToken,
+		utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR,
+	)
+	if !ok {
+		return
+	}
+	attemptsRemaining, err := dbhelper.CreatePasswordResetCode(passwordResetRequest.Email, GetClientInfo(r))
 	if err != nil {
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, utils.GENERIC_PASSWORD_RESET_REQUEST_ERROR)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(StatusResponse{
 		Status: "Check your email! A verification code has been sent if an account was found with this email.",
+		Lockout: &utils.LockoutDetails{AttemptsRemaining: attemptsRemaining},
 	})
 }
 
 func ResetPassword(w http.ResponseWriter, r *http.Request) {
 	passwordResetAttempt, err := DecodeValidBody[PasswordResetAttempt](r)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_PASSWORD_RESET_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_PASSWORD_RESET_ERROR)
 		return
 	}
 	passwordHash, err := utils.HashPassword(passwordResetAttempt.Password)
 	if err != nil {
-		GenericAuthError(w, err, utils.GENERIC_PASSWORD_RESET_ERROR)
+		GenericAuthError(w, r, err, utils.GENERIC_PASSWORD_RESET_ERROR)
 		return
 	}
-	err, errMessage := dbhelper.VerifyPasswordResetCode(
+	attemptsRemaining, err := dbhelper.VerifyPasswordResetCode(
 		passwordResetAttempt.Email, 
 		passwordResetAttempt.Code, 
 		passwordHash,
+		GetClientInfo(r),
 	)
 	if err != nil {
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, utils.GENERIC_PASSWORD_RESET_ERROR)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(StatusResponse{
 		Status: "The password has been reset if an account was found with this email! Please log in now.",
+		Lockout: &utils.LockoutDetails{AttemptsRemaining: attemptsRemaining},
 	})
 }
 
 func RefreshJWTToken(w http.ResponseWriter, r *http.Request) {
-	refreshTokenBody, err := DecodeValidBody[RefreshTokenBody](r)
+	refreshToken, err := _GetRefreshToken(r)
 	if err != nil {
-		GenericAuthError(w, err, utils.JWT_TOKEN_PARSING_ERROR)
+		GenericAuthError(w, r, err, utils.JWT_TOKEN_PARSING_ERROR)
 		return
 	}
-	claims, err, errMessage := utils.VerifyJWTToken(utils.REFRESH_TYPE, refreshTokenBody.TokenString)
+	claims, err, errMessage := utils.VerifyJWTToken(utils.REFRESH_TYPE, refreshToken)
 	if err != nil {
 		// if err, then the refresh token is not valid anymore, and you need to log in again
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, errMessage)
 		return
 	}
-	displayName, ok := claims["displayName"].(string)
+	userID, ok := utils.GetUserID(claims)
 	if !ok {
-		GenericAuthError(w, err, utils.JWT_TOKEN_PARSING_ERROR)
+		GenericAuthError(w, r, err, utils.JWT_TOKEN_PARSING_ERROR)
+		return
+	}
+	sessionID := utils.GetSessionID(claims)
+	if !middlewares.CheckCSRF(w, r, sessionID) {
+		return
+	}
+	tokens, err := dbhelper.ReplaceRefreshToken(
+		userID,
+		sessionID,
+		refreshToken,
+		GetClientInfo(r),
+	)
+	if err != nil {
+		GenericAuthError(w, r, err, utils.SERVER_DOWN)
 		return
 	}
-	newAccessToken, err := utils.CreateJWTToken(displayName, "access")
+	WriteTokenResponse(w, tokens)
+}
+
+// Signs the session out: its refresh token stops working and with cookie sessions
+// the browser drops the session cookies. Access tokens already issued last until
+// they expire.
+func RevokeRefreshToken(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := _GetRefreshToken(r)
 	if err != nil {
-		GenericAuthError(w, err, utils.JWT_TOKEN_PARSING_ERROR)
+		GenericAuthError(w, r, err, utils.JWT_TOKEN_PARSING_ERROR)
 		return
 	}
-	newRefreshToken, err := utils.CreateJWTToken(displayName, "refresh")
+	claims, err, errMessage := utils.VerifyJWTToken(utils.REFRESH_TYPE, refreshToken)
 	if err != nil {
-		GenericAuthError(w, err, utils.JWT_TOKEN_PARSING_ERROR)
+		// the cookies are of no use anymore either
+		if middlewares.CookieSessions() {
+			middlewares.ClearSessionCookies(w)
+		}
+		GenericAuthError(w, r, err, errMessage)
 		return
 	}
-	err, errMessage = dbhelper.ReplaceRefreshToken(displayName, refreshTokenBody.TokenString, newRefreshToken)
+	userID, ok := utils.GetUserID(claims)
+	if !ok {
+		GenericAuthError(w, r, err, utils.JWT_TOKEN_PARSING_ERROR)
+		return
+	}
+	// otherwise other sites could sign users out
+	if !middlewares.CheckCSRF(w, r, utils.GetSessionID(claims)) {
+		return
+	}
+	err = dbhelper.RevokeRefreshToken(userID, refreshToken, GetClientInfo(r))
 	if err != nil {
-		GenericAuthError(w, err, errMessage)
+		GenericAuthError(w, r, err, utils.SERVER_DOWN)
 		return
 	}
+	if middlewares.CookieSessions() {
+		middlewares.ClearSessionCookies(w)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TokenResponse{
-		AccessToken: newAccessToken, 
-		RefreshToken: newRefreshToken,
-	})
+	json.NewEncoder(w).Encode(StatusResponse{Status: "You've been signed out."})
+}
+
+// Reads the refresh token from its cookie with cookie sessions, or else from the
+// request body.
+func _GetRefreshToken(r *http.Request) (string, error) {
+	if middlewares.CookieSessions() {
+		return middlewares.GetRefreshCookie(r)
+	}
+	refreshTokenBody, err := DecodeValidBody[RefreshTokenBody](r)
+	return refreshTokenBody.TokenString, err
 }
\ No newline at end of file
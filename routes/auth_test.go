@@ -0,0 +1,219 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Builds the routes on a fresh memory database and attempt store, the way main
+// does with database.driver set to memory.
+func _NewTestRouter(t *testing.T, configure func(*config.Config)) *mux.Router {
+	cfg := config.Default()
+	cfg.Database.Driver = config.DATABASE_DRIVER_MEMORY
+	cfg.AttemptStore.Backend = config.ATTEMPT_STORE_MEMORY
+	for _, secret := range []*string{&cfg.JWT.AccessSecret, &cfg.JWT.RefreshSecret} {
+		var err error
+		*secret, err = utils.GenerateJWTSecret()
+		if err != nil {
+			t.Fatalf("GenerateJWTSecret: %v", err)
+		}
+	}
+	if configure != nil {
+		configure(&cfg)
+	}
+	err := dbhelper.OpenDB(cfg.Database)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	err = dbhelper.OpenAttemptStore(cfg.AttemptStore)
+	if err != nil {
+		t.Fatalf("OpenAttemptStore: %v", err)
+	}
+	dbhelper.SetLockoutPolicies(cfg.Lockout)
+	dbhelper.SetStuffingPolicy(cfg.Stuffing)
+	dbhelper.SetPasswordResetConfig(cfg.PasswordReset)
+	dbhelper.SetAuditConfig(cfg.Audit)
+	utils.SetJWTConfig(cfg.JWT)
+	r := mux.NewRouter()
+	err = CreateRoutes(r, &cfg)
+	if err != nil {
+		t.Fatalf("CreateRoutes: %v", err)
+	}
+	return r
+}
+
+// Sends body as JSON, with accessToken as bearer token when it's set.
+func _Request(r http.Handler, method, path string, body interface{}, accessToken string) *httptest.ResponseRecorder {
+	var payload strings.Builder
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(payload.String()))
+	req.Header.Set("Content-Type", "application/json")
+	if len(accessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer " + accessToken)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func _Decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	var body T
+	err := json.Unmarshal(rec.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func _ExpectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d with body %s, want %d", rec.Code, rec.Body.String(), status)
+	}
+}
+
+var testSignup = SignupAttempt{
+	Email: "ada@example.com",
+	DisplayName: "ada_lovelace",
+	Password: "correct horse battery",
+	ConfirmPassword: "correct horse battery",
+}
+
+func TestSignupLoginRefresh(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+
+	rec := _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	signup := _Decode[TokenResponse](t, rec)
+	if len(signup.AccessToken) == 0 || len(signup.RefreshToken) == 0 {
+		t.Fatalf("signup returned %+v, want both tokens", signup)
+	}
+
+	rec = _Request(r, "POST", AUTH_PREFIX + "/login", LoginAttempt{Email: testSignup.Email, Password: testSignup.Password}, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	login := _Decode[TokenResponse](t, rec)
+
+	rec = _Request(r, "POST", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, RefreshTokenBody{TokenString: login.RefreshToken}, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	refresh := _Decode[TokenResponse](t, rec)
+	if len(refresh.AccessToken) == 0 || len(refresh.RefreshToken) == 0 {
+		t.Fatalf("refresh returned %+v, want both tokens", refresh)
+	}
+
+	rec = _Request(r, "GET", AUTH_PREFIX + "/me", nil, refresh.AccessToken)
+	_ExpectStatus(t, rec, http.StatusOK)
+	profile := _Decode[ProfileResponse](t, rec)
+	if profile.Email != testSignup.Email || profile.DisplayName != testSignup.DisplayName {
+		t.Errorf("profile is %+v, want the signed up user", profile)
+	}
+}
+
+func TestLoginWrongPassword(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	_ExpectStatus(t, _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, ""), http.StatusOK)
+
+	rec := _Request(r, "POST", AUTH_PREFIX + "/login", LoginAttempt{Email: testSignup.Email, Password: "wrong password"}, "")
+	_ExpectStatus(t, rec, http.StatusUnauthorized)
+	body := _Decode[utils.ErrorResponse](t, rec)
+	if body.Error.Code != utils.ERROR_CODE_INVALID_CREDENTIALS {
+		t.Errorf("error code is %q, want %q", body.Error.Code, utils.ERROR_CODE_INVALID_CREDENTIALS)
+	}
+	if body.Error.Lockout == nil || body.Error.Lockout.AttemptsRemaining != dbhelper.LoginLockout.MaxAttempts - 1 {
+		t.Errorf("lockout details are %+v, want %d attempts remaining", body.Error.Lockout, dbhelper.LoginLockout.MaxAttempts - 1)
+	}
+}
+
+func TestRefreshRejectsAccessToken(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	rec := _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	signup := _Decode[TokenResponse](t, rec)
+
+	rec = _Request(r, "POST", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, RefreshTokenBody{TokenString: signup.AccessToken}, "")
+	_ExpectStatus(t, rec, http.StatusUnauthorized)
+}
+
+// Tokens name users by ID, so someone who signs up with a freed display name
+// doesn't inherit the sessions of its previous owner.
+func TestRenameKeepsSessionsWithTheirUser(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	rec := _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	ada := _Decode[TokenResponse](t, rec)
+
+	rec = _Request(r, "PATCH", AUTH_PREFIX + "/me", ProfileUpdate{DisplayName: "countess"}, ada.AccessToken)
+	_ExpectStatus(t, rec, http.StatusOK)
+	mallory := testSignup
+	mallory.Email = "mallory@example.com"
+	_ExpectStatus(t, _Request(r, "POST", AUTH_PREFIX + "/signup", mallory, ""), http.StatusOK)
+
+	rec = _Request(r, "GET", AUTH_PREFIX + "/me", nil, ada.AccessToken)
+	_ExpectStatus(t, rec, http.StatusOK)
+	profile := _Decode[ProfileResponse](t, rec)
+	if profile.Email != testSignup.Email || profile.DisplayName != "countess" {
+		t.Errorf("the renamed user's token shows %+v", profile)
+	}
+	rec = _Request(r, "POST", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, RefreshTokenBody{TokenString: ada.RefreshToken}, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+}
+
+func TestLoginLastAttemptReportsZeroRemaining(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	_ExpectStatus(t, _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, ""), http.StatusOK)
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < dbhelper.LoginLockout.MaxAttempts; i++ {
+		rec = _Request(r, "POST", AUTH_PREFIX + "/login", LoginAttempt{Email: testSignup.Email, Password: "wrong password"}, "")
+	}
+	_ExpectStatus(t, rec, http.StatusUnauthorized)
+	body := _Decode[utils.ErrorResponse](t, rec)
+	if body.Error.Lockout == nil || body.Error.Lockout.AttemptsRemaining != 0 {
+		t.Errorf("lockout details are %+v, want 0 attempts remaining", body.Error.Lockout)
+	}
+}
+
+func TestPasswordResetReportsAttemptsRemaining(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	_ExpectStatus(t, _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, ""), http.StatusOK)
+
+	rec := _Request(r, "POST", AUTH_PREFIX + "/request_password_reset", PasswordResetRequest{Email: testSignup.Email}, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	body := _Decode[StatusResponse](t, rec)
+	if body.Lockout == nil || body.Lockout.AttemptsRemaining != dbhelper.ResetRequestLockout.MaxAttempts - 1 {
+		t.Errorf("reset request lockout details are %+v, want %d attempts remaining", body.Lockout, dbhelper.ResetRequestLockout.MaxAttempts - 1)
+	}
+
+	attempt := PasswordResetAttempt{
+		Email: testSignup.Email,
+		Code: "wrong code",
+		Password: "another horse battery",
+		ConfirmPassword: "another horse battery",
+	}
+	rec = _Request(r, "POST", AUTH_PREFIX + "/reset_password", attempt, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	body = _Decode[StatusResponse](t, rec)
+	if body.Lockout == nil || body.Lockout.AttemptsRemaining != dbhelper.ResetAttemptLockout.MaxAttempts - 1 {
+		t.Errorf("reset attempt lockout details are %+v, want %d attempts remaining", body.Lockout, dbhelper.ResetAttemptLockout.MaxAttempts - 1)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	rec := _Request(r, "POST", AUTH_PREFIX + "/signup", testSignup, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	signup := _Decode[TokenResponse](t, rec)
+
+	body := RefreshTokenBody{TokenString: signup.RefreshToken}
+	_ExpectStatus(t, _Request(r, "DELETE", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, body, ""), http.StatusOK)
+	_ExpectStatus(t, _Request(r, "POST", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, body, ""), http.StatusUnauthorized)
+	// signing out again is harmless
+	_ExpectStatus(t, _Request(r, "DELETE", AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, body, ""), http.StatusOK)
+}
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/logging"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"encoding/json"
+	"context"
+	"time"
+)
+
+const CHECK_OK = "ok"
+const CHECK_FAILED = "failed"
+
+// Errors aren't included, the probe is public and they can name hosts and
+// users. They're logged with the request ID instead.
+type CheckResult struct {
+	Status string `json:"status"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// What /readyz checks, by the name it reports them under. The mailer check the
+// readiness request asked for waits for a mailer, utils/mailer.go is still empty.
+var readinessChecks = map[string]func(ctx context.Context) error{
+	"database": dbhelper.PingDB,
+	"migrations": func(ctx context.Context) error {
+		return dbhelper.CheckSchema()
+	},
+	"jwtKeys": func(ctx context.Context) error {
+		return utils.CheckJWTKeys()
+	},
+}
+
+func HealthRouter(r *mux.Router) {
+	r.HandleFunc("/healthz", Healthz).Methods("GET")
+	r.HandleFunc("/readyz", Readyz).Methods("GET")
+}
+
+// Answers as long as the process is serving requests.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	_WriteHealth(w, http.StatusOK, HealthResponse{Status: CHECK_OK})
+}
+
+// Checks every dependency, answering 503 if any of them failed.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	const CHECK_TIMEOUT = 2 * time.Second
+	ctx, cancel := context.WithTimeout(r.Context(), CHECK_TIMEOUT)
+	defer cancel()
+	response := HealthResponse{Status: CHECK_OK, Checks: map[string]CheckResult{}}
+	status := http.StatusOK
+	for name, check := range readinessChecks {
+		err := check(ctx)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("Readiness check failed", "check", name, "err", err)
+			response.Checks[name] = CheckResult{Status: CHECK_FAILED}
+			response.Status = CHECK_FAILED
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = CheckResult{Status: CHECK_OK}
+	}
+	_WriteHealth(w, status, response)
+}
+
+func _WriteHealth(w http.ResponseWriter, status int, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	// probes must see the current state, not a cached one
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,275 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/openapi"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"encoding/json"
+	"encoding/base64"
+	"crypto/sha256"
+	"strings"
+	_ "embed"
+)
+
+const OPENAPI_PATH = "/api/openapi.json"
+const DOCS_PATH = "/api/docs"
+
+var apiInfo = openapi.Info{
+	Title: "Auth API",
+	Description: "Signup, login, password reset and session management.",
+	Version: "1.0.0",
+}
+
+// Every route CreateRoutes registers. CreateRoutes fails when a route is missing
+// here or one listed here isn't registered, so add new routes to both.
+var Operations = []openapi.Operation{
+	{
+		Method: "POST", Path: "/api/auth/login", Tag: "auth",
+		Summary: "Log in with an email and password",
+		Request: LoginAttempt{}, Response: TokenResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_INVALID_CREDENTIALS,
+			utils.ERROR_CODE_CAPTCHA_REQUIRED,
+			utils.ERROR_CODE_LOCKED_OUT,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "POST", Path: "/api/auth/signup", Tag: "auth",
+		Summary: "Create an account and log in",
+		Request: SignupAttempt{}, Response: TokenResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_EMAIL_TAKEN,
+			utils.ERROR_CODE_DISPLAY_NAME_TAKEN,
+			utils.ERROR_CODE_CAPTCHA_REQUIRED,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "POST", Path: "/api/auth/request_password_reset", Tag: "auth",
+		Summary: "Email a password reset code, if the email has an account",
+		Request: PasswordResetRequest{}, Response: StatusResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_CAPTCHA_REQUIRED,
+			utils.ERROR_CODE_LOCKED_OUT,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "POST", Path: "/api/auth/reset_password", Tag: "auth",
+		Summary: "Set a new password with a reset code",
+		Request: PasswordResetAttempt{}, Response: StatusResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_LOCKED_OUT,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "POST", Path: "/api/auth/refresh_jwt_token", Tag: "auth",
+		Summary: "Trade a refresh token for a new access and refresh token, with cookie sessions the refresh token is read from its cookie instead of the body and the session's CSRF token must be in the CSRF header",
+		Request: RefreshTokenBody{}, Response: TokenResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_CSRF_FAILED,
+			utils.ERROR_CODE_INVALID_TOKEN,
+			utils.ERROR_CODE_TOKEN_EXPIRED,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "DELETE", Path: "/api/auth/refresh_jwt_token", Tag: "auth",
+		Summary: "Sign the session out, with cookie sessions the refresh token is read from its cookie, the session's CSRF token must be in the CSRF header and both cookies are expired",
+		Request: RefreshTokenBody{}, Response: StatusResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_CSRF_FAILED,
+			utils.ERROR_CODE_INVALID_TOKEN,
+			utils.ERROR_CODE_TOKEN_EXPIRED,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "GET", Path: "/api/auth/me", Tag: "profile", Authenticated: true,
+		Summary: "Get the caller's profile",
+		Response: ProfileResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_INVALID_TOKEN,
+			utils.ERROR_CODE_TOKEN_EXPIRED,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "PATCH", Path: "/api/auth/me", Tag: "profile", Authenticated: true,
+		Summary: "Update the caller's profile, renaming keeps the caller's sessions",
+		Request: ProfileUpdate{}, Response: ProfileResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_MALFORMED_REQUEST,
+			utils.ERROR_CODE_VALIDATION_FAILED,
+			utils.ERROR_CODE_INVALID_TOKEN,
+			utils.ERROR_CODE_TOKEN_EXPIRED,
+			utils.ERROR_CODE_DISPLAY_NAME_TAKEN,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "GET", Path: "/api/admin/auth_events", Tag: "admin", Authenticated: true,
+		Summary: "List audit events, newest first",
+		Query: []openapi.Parameter{
+			{Name: "userId", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "email", In: "query", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "type", In: "query", Schema: &openapi.Schema{Type: "string", Enum: models.AUTH_EVENT_TYPES}},
+			{Name: "from", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+			{Name: "to", In: "query", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+			{Name: "limit", In: "query", Description: "Defaults to 100.", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		Response: AuthEventsResponse{},
+		Errors: []string{
+			utils.ERROR_CODE_INVALID_QUERY,
+			utils.ERROR_CODE_INVALID_TOKEN,
+			utils.ERROR_CODE_TOKEN_EXPIRED,
+			utils.ERROR_CODE_FORBIDDEN,
+			utils.ERROR_CODE_RATE_LIMITED,
+			utils.ERROR_CODE_INTERNAL,
+		},
+	},
+	{
+		Method: "GET", Path: "/metrics", Tag: "operations",
+		Summary: "Prometheus metrics, for a client certificate. Scrape server.metricsAddr instead when there's none",
+		ResponseType: "text/plain",
+		Errors: []string{utils.ERROR_CODE_FORBIDDEN},
+	},
+	{
+		Method: "GET", Path: "/healthz", Tag: "operations",
+		Summary: "Liveness probe",
+		Response: HealthResponse{},
+	},
+	{
+		Method: "GET", Path: "/readyz", Tag: "operations",
+		Summary: "Readiness probe, checking each dependency",
+		Response: HealthResponse{},
+		Responses: map[int]interface{}{http.StatusServiceUnavailable: HealthResponse{}},
+	},
+	{
+		Method: "GET", Path: OPENAPI_PATH, Tag: "operations",
+		Summary: "This document",
+	},
+	{
+		Method: "GET", Path: DOCS_PATH, Tag: "operations",
+		Summary: "Interactive documentation for this document",
+		ResponseType: "text/html",
+	},
+}
+
+var openAPIDocument []byte
+
+// The document as published to clients. Tests and authctl openapi check compare
+// Operations with it, regenerate it with authctl openapi print when the API
+// changes on purpose.
+//go:embed openapi.json
+var PublishedOpenAPI []byte
+
+// Serves the OpenAPI document of every route registered on r so far and a docs
+// page for it. Fails when r and Operations differ, PublishedOpenAPI is left to
+// the tests so a stale copy doesn't keep the server from starting.
+func OpenAPIRouter(r *mux.Router) error {
+	r.HandleFunc(OPENAPI_PATH, GetOpenAPIDocument).Methods("GET")
+	r.HandleFunc(DOCS_PATH, GetDocs).Methods("GET")
+	err := openapi.Check(r, Operations, nil)
+	if err != nil {
+		return err
+	}
+	doc, err := openapi.Generate(apiInfo, Operations)
+	if err != nil {
+		return err
+	}
+	openAPIDocument, err = json.MarshalIndent(doc, "", "  ")
+	return err
+}
+
+// The document OpenAPIRouter generated.
+func OpenAPIDocument() []byte {
+	return openAPIDocument
+}
+
+func GetOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// Swagger UI, loaded from a CDN, pointed at OPENAPI_PATH. The CSP only lets the
+// page load the two pinned files and run its own inline script, and only fetch
+// from us. The page is on our origin, so anything else it ran could read the CSRF
+// cookie.
+func GetDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsPolicy)
+	w.Write([]byte(docsPage))
+}
+
+// Bump the version and both hashes together, TestDocsAssetIntegrity prints the
+// hashes of the pinned files when they don't match. Browsers skip the check for
+// an empty hash, so TestDocsAssetIntegrity fails on one even offline.
+const DOCS_UI_VERSION = "5.17.14"
+const DOCS_UI_URL = "https://unpkg.com/swagger-ui-dist@" + DOCS_UI_VERSION
+const DOCS_UI_CSS_INTEGRITY = ""
+const DOCS_UI_JS_INTEGRITY = ""
+const DOCS_UI_CSS = DOCS_UI_URL + "/swagger-ui.css"
+const DOCS_UI_JS = DOCS_UI_URL + "/swagger-ui-bundle.js"
+
+const docsScript = `
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "` + OPENAPI_PATH + `", dom_id: "#swagger-ui"});
+    };
+  `
+
+var docsPolicy = strings.Join([]string{
+	"default-src 'none'",
+	"script-src 'sha256-" + _SHA256Base64(docsScript) + "' " + DOCS_UI_JS,
+	// Swagger UI sets inline styles
+	"style-src 'unsafe-inline' " + DOCS_UI_CSS,
+	"img-src 'self' data:",
+	"connect-src 'self'",
+	"base-uri 'none'",
+	"form-action 'none'",
+	"frame-ancestors 'none'",
+}, "; ")
+
+var docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Auth API</title>
+  <link rel="stylesheet" href="` + DOCS_UI_CSS + `" integrity="` + DOCS_UI_CSS_INTEGRITY + `" crossorigin="anonymous">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + DOCS_UI_JS + `" integrity="` + DOCS_UI_JS_INTEGRITY + `" crossorigin="anonymous"></script>
+  <script>` + docsScript + `</script>
+</body>
+</html>
+`
+
+func _SHA256Base64(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/openapi"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"encoding/base64"
+	"crypto/sha512"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func _NewOpenAPITestRouter(t *testing.T) *mux.Router {
+	cfg := config.Default()
+	r := mux.NewRouter()
+	err := CreateRoutes(r, &cfg)
+	if err != nil {
+		t.Fatalf("CreateRoutes: %v", err)
+	}
+	return r
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	r := _NewOpenAPITestRouter(t)
+	err := openapi.Check(r, Operations, PublishedOpenAPI)
+	if err != nil {
+		t.Fatalf("%v\nIf the change is meant, run: go run ./cmd/authctl openapi print > routes/openapi.json", err)
+	}
+}
+
+func TestOpenAPICheckFindsUndocumentedRoutes(t *testing.T) {
+	r := _NewOpenAPITestRouter(t)
+	r.HandleFunc("/api/auth/undocumented", Healthz).Methods("GET")
+	err := openapi.Check(r, Operations, nil)
+	if err == nil {
+		t.Fatalf("Check passed with an undocumented route")
+	}
+}
+
+func TestOpenAPICheckFindsChangedOperations(t *testing.T) {
+	r := _NewOpenAPITestRouter(t)
+	changes := map[string]func(operation *openapi.Operation){
+		"an error code": func(operation *openapi.Operation) {
+			operation.Errors = append(operation.Errors, utils.ERROR_CODE_FORBIDDEN)
+		},
+		"the request body": func(operation *openapi.Operation) {
+			operation.Request = RefreshTokenBody{}
+		},
+		"the response body": func(operation *openapi.Operation) {
+			operation.Response = StatusResponse{}
+		},
+		"a status": func(operation *openapi.Operation) {
+			operation.Responses = map[int]interface{}{http.StatusAccepted: StatusResponse{}}
+		},
+	}
+	for name, change := range changes {
+		operations := append([]openapi.Operation{}, Operations...)
+		for i := range operations {
+			if operations[i].Method == "POST" && operations[i].Path == AUTH_PREFIX + "/login" {
+				change(&operations[i])
+			}
+		}
+		err := openapi.Check(r, operations, PublishedOpenAPI)
+		if err == nil {
+			t.Errorf("Check passed after changing %s of POST /api/auth/login", name)
+		}
+	}
+}
+
+func TestDocsPolicy(t *testing.T) {
+	rec := httptest.NewRecorder()
+	GetDocs(rec, httptest.NewRequest("GET", DOCS_PATH, nil))
+	policy := rec.Header().Get("Content-Security-Policy")
+	if !strings.Contains(policy, "'sha256-" + _SHA256Base64(docsScript) + "'") {
+		t.Errorf("the policy %q doesn't allow the page's inline script", policy)
+	}
+	if !strings.Contains(rec.Body.String(), "<script>" + docsScript + "</script>") {
+		t.Errorf("the page doesn't have the inline script the policy allows")
+	}
+	if strings.Contains(rec.Body.String(), "swagger-ui-dist@5/") {
+		t.Errorf("the page loads a floating Swagger UI version")
+	}
+}
+
+func TestDocsPolicyOnlyAllowsThePinnedAssets(t *testing.T) {
+	for _, directive := range strings.Split(docsPolicy, "; ") {
+		for _, source := range strings.Fields(directive)[1:] {
+			if strings.HasPrefix(source, "https://") && source != DOCS_UI_JS && source != DOCS_UI_CSS {
+				t.Errorf("the policy's %q allows %s, not just a pinned file", directive, source)
+			}
+		}
+	}
+}
+
+// Only comparing the hashes needs the network, that part is skipped when the CDN
+// can't be reached.
+func TestDocsAssetIntegrity(t *testing.T) {
+	assets := map[string]string{
+		DOCS_UI_CSS: DOCS_UI_CSS_INTEGRITY,
+		DOCS_UI_JS: DOCS_UI_JS_INTEGRITY,
+	}
+	for url, integrity := range assets {
+		if !strings.HasPrefix(integrity, "sha384-") {
+			t.Errorf("the integrity of %s is %q, browsers don't check it without a sha384 hash", url, integrity)
+		}
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for url, integrity := range assets {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Skipf("can't reach the CDN: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("fetching %s: status %d, %v", url, resp.StatusCode, err)
+		}
+		sum := sha512.Sum384(body)
+		want := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+		if integrity != want {
+			t.Errorf("the integrity of %s is %q, the pinned file's is %q", url, integrity, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/dbhelper"
+	"github.com/shoppingapp/apiv1/middlewares"
+	"github.com/shoppingapp/apiv1/models"
+	"github.com/shoppingapp/apiv1/utils"
+	"github.com/gorilla/mux"
+	"net/http"
+	"encoding/json"
+	"time"
+)
+
+type ProfileResponse struct {
+	ID uint `json:"id"`
+	Email string `json:"email"`
+	DisplayName string `json:"displayName"`
+	EmailVerified bool `json:"emailVerified"`
+	PhoneVerified bool `json:"phoneVerified"`
+	TwoFactorEnabled bool `json:"twoFactorEnabled"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ProfileUpdate struct {
+	DisplayName string `validate:"required,min=4,max=64"`
+}
+
+func ProfileRouter(s *mux.Router, limits config.RateLimitConfig) {
+	// GET and PATCH share one budget, limits.profile covers /me as a whole
+	profileLimit := middlewares.RateLimiter(limits.Profile)
+	s.HandleFunc("/me", profileLimit(middlewares.IsAccessTokenAuthorized(GetProfile))).Methods("GET")
+	s.HandleFunc(
+		"/me",
+		// bearer authenticated, so browsers don't attach the credential on their own
+		// and there's nothing to forge
+		profileLimit(middlewares.IsAccessTokenAuthorized(UpdateProfile)),
+	).Methods("PATCH")
+}
+
+func NewProfileResponse(user models.User) ProfileResponse {
+	return ProfileResponse{
+		ID: user.ID,
+		Email: user.Email,
+		DisplayName: user.DisplayName,
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		TwoFactorEnabled: user.TwoFactorEnabled,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := dbhelper.GetUserByID(middlewares.GetUserID(r))
+	if err != nil {
+		GenericAuthError(w, r, err, utils.GENERIC_PROFILE_ERROR)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewProfileResponse(user))
+}
+
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	profileUpdate, err := DecodeValidBody[ProfileUpdate](r)
+	if err != nil {
+		GenericAuthError(w, r, err, utils.GENERIC_PROFILE_UPDATE_ERROR)
+		return
+	}
+	user, err := dbhelper.UpdateProfile(middlewares.GetUserID(r), profileUpdate.DisplayName)
+	if err != nil {
+		GenericAuthError(w, r, err, utils.GENERIC_PROFILE_UPDATE_ERROR)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewProfileResponse(user))
+}
\ No newline at end of file
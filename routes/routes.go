@@ -1,21 +1,80 @@
 package routes
 
 import (
+	"github.com/shoppingapp/apiv1/captcha"
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/metrics"
+	"github.com/shoppingapp/apiv1/middlewares"
 	"github.com/gorilla/mux"
 	"github.com/go-playground/validator/v10"
+	"strings"
+	"errors"
+	"sort"
+	"fmt"
 )
-// "time"
-// "golang.org/x/time/rate"
 
-var validate *validator.Validate
+const AUTH_PREFIX = "/api/auth"
+const ADMIN_PREFIX = "/api/admin"
 
-// type visitor struct {
-// 	limiter  *rate.Limiter
-// 	lastSeen time.Time
-// }
+var validate *validator.Validate
+var captchaConfig config.CaptchaConfig
+var captchaVerifier captcha.CaptchaVerifier
 
-func CreateRoutes(r *mux.Router) {
+func CreateRoutes(r *mux.Router, cfg *config.Config) error {
 	validate = validator.New()
-	s := r.PathPrefix("/api/auth").Subrouter()
-	AuthRouter(s)
-}
\ No newline at end of file
+	middlewares.SetTrustedProxyHops(cfg.Server.TrustedProxyHops)
+	middlewares.SetSessionConfig(cfg.Session, AUTH_PREFIX + REFRESH_JWT_TOKEN_PATH, cfg.JWT.RefreshTokenDuration)
+	var err error
+	captchaConfig = cfg.Captcha
+	captchaVerifier, err = captcha.NewVerifier(cfg.Captcha.Provider, cfg.Captcha.Secret)
+	if err != nil {
+		return err
+	}
+	s := r.PathPrefix(AUTH_PREFIX).Subrouter()
+	AuthRouter(s, cfg.RateLimits)
+	ProfileRouter(s, cfg.RateLimits)
+	AdminRouter(r.PathPrefix(ADMIN_PREFIX).Subrouter(), cfg.RateLimits)
+	// scrapers use server.metricsAddr, or client certificates on this listener
+	r.HandleFunc("/metrics", middlewares.IsClientCertAuthorized(metrics.Handler().ServeHTTP)).Methods("GET")
+	HealthRouter(r)
+	r.Use(middlewares.Metrics)
+	err = OpenAPIRouter(r)
+	if err != nil {
+		return err
+	}
+	return _CheckCORSPrefixes(r, cfg.CORS)
+}
+
+// A CORS policy for a prefix no route is under is most likely a typo, which would
+// leave the routes it meant without their policy.
+func _CheckCORSPrefixes(r *mux.Router, cors config.CORSConfig) error {
+	var paths []string
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err == nil {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	for prefix := range cors {
+		routed := false
+		for _, path := range paths {
+			if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/") + "/") {
+				routed = true
+				break
+			}
+		}
+		if !routed {
+			unknown = append(unknown, prefix)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errors.New(fmt.Sprintf("No route is under the CORS prefixes %s.", strings.Join(unknown, ", ")))
+	}
+	return nil
+}
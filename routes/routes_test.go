@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Without server.tls.clientAuth nobody has a client certificate, so the public
+// listener never serves metrics.
+func TestMetricsNeedsClientCert(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	_ExpectStatus(t, _Request(r, "GET", "/metrics", nil, ""), http.StatusForbidden)
+}
+
+func TestReadyz(t *testing.T) {
+	r := _NewTestRouter(t, nil)
+	rec := _Request(r, "GET", "/readyz", nil, "")
+	_ExpectStatus(t, rec, http.StatusOK)
+	body := _Decode[HealthResponse](t, rec)
+	for name, check := range body.Checks {
+		if check.Status != CHECK_OK {
+			t.Errorf("check %s is %q, want %q", name, check.Status, CHECK_OK)
+		}
+	}
+}
+
+func TestCreateRoutesRefusesUnknownCORSPrefix(t *testing.T) {
+	cfg := config.Default()
+	cfg.CORS["/api/auth/typo"] = cfg.CORS[AUTH_PREFIX]
+	err := CreateRoutes(mux.NewRouter(), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "/api/auth/typo") {
+		t.Errorf("CreateRoutes returned %v, want an error naming the unknown prefix", err)
+	}
+}
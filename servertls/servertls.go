@@ -0,0 +1,156 @@
+package servertls
+
+import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/utils"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"net"
+	"sync"
+	"errors"
+	"time"
+	"fmt"
+	"os"
+)
+
+// Only forward secret AEAD suites for TLS 1.2, TLS 1.3 suites aren't configurable.
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// Builds the HTTPS server's TLS config, loading the certificate through a
+// CertReloader so it can be renewed without a restart.
+func NewConfig(cfg config.ServerTLSConfig) (*tls.Config, error) {
+	reloader, err := NewCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: cipherSuites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.MinVersion == config.TLS_VERSION_1_3 {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	switch cfg.ClientAuth {
+	case config.CLIENT_AUTH_OPTIONAL:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.CLIENT_AUTH_REQUIRE:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.ClientAuth != config.CLIENT_AUTH_NONE {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in %s.", cfg.ClientCAFile))
+		}
+		tlsConfig.ClientCAs = clientCAs
+	}
+	return tlsConfig, nil
+}
+
+// Serves a certificate and key pair from disk, loading them again once either
+// file changes, e.g. when certbot renews them.
+type CertReloader struct {
+	certFile string
+	keyFile string
+	mu sync.Mutex
+	cert *tls.Certificate
+	modTime time.Time
+	checkedAt time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	err := reloader._Load()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Meant for tls.Config.GetCertificate. A pair that fails to load, e.g. because
+// only one of the files has been replaced so far, is logged and the previous
+// one kept until the next check.
+func (c *CertReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// handshakes can come in fast, the files only need looking at now and then
+	const CHECK_INTERVAL = 10 * time.Second
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checkedAt) >= CHECK_INTERVAL {
+		c.checkedAt = time.Now()
+		modTime, err := c._ModTime()
+		if err == nil && !modTime.Equal(c.modTime) {
+			err = c._Load()
+			if err == nil {
+				slog.Info("Reloaded the TLS certificate", "cert_file", c.certFile)
+			}
+		}
+		if err != nil {
+			slog.Error("Couldn't reload the TLS certificate, keeping the previous one", "err", err)
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *CertReloader) _Load() error {
+	modTime, err := c._ModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime, c.checkedAt = &cert, modTime, time.Now()
+	return nil
+}
+
+// The later modification time of the two files.
+func (c *CertReloader) _ModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Sends plain HTTP GET and HEAD requests to the same host and path over HTTPS on
+// the port of httpsAddr. Other methods are refused rather than redirected, their
+// body (a password, a token) already crossed the network in the clear and a
+// redirect would teach clients that it works.
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			utils.WriteError(w, utils.NewAPIError(http.StatusForbidden, utils.ERROR_CODE_FORBIDDEN, utils.HTTPS_REQUIRED_ERROR))
+			return
+		}
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = hostname
+		}
+		if len(httpsPort) > 0 && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://" + host + r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,32 @@
+package servertls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	handler := RedirectHandler(":8443")
+	for _, method := range []string{"GET", "HEAD"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "http://example.com/api/auth/me?x=1", nil))
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s got status %d, want %d", method, rec.Code, http.StatusPermanentRedirect)
+		}
+		if location := rec.Header().Get("Location"); location != "https://example.com:8443/api/auth/me?x=1" {
+			t.Errorf("%s redirected to %q", method, location)
+		}
+	}
+	for _, method := range []string{"POST", "PATCH", "PUT", "DELETE"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "http://example.com/api/auth/login", strings.NewReader("{}")))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s got status %d, want %d", method, rec.Code, http.StatusForbidden)
+		}
+		if location := rec.Header().Get("Location"); len(location) > 0 {
+			t.Errorf("%s was redirected to %q", method, location)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+	"fmt"
+)
+
+// Hashes an audit event together with the hash of the event before it, so
+// changing, removing or reordering any event changes every hash after it. Seq,
+// CreatedAt and the event's contents are covered; ID isn't since the database
+// assigns it. Never change the encoding, chains recorded with the old one would
+// no longer verify.
+func HashEvent(prevHash string, event models.AuthEvent) string {
+	userID := ""
+	if event.UserID != nil {
+		userID = strconv.FormatUint(uint64(*event.UserID), 10)
+	}
+	fields := []string{
+		prevHash,
+		strconv.FormatUint(uint64(event.Seq), 10),
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		event.Type,
+		userID,
+		event.Email,
+		event.IP,
+		event.UserAgent,
+		event.Detail,
+	}
+	h := sha256.New()
+	for _, field := range fields {
+		// length prefixed so no two different events encode the same
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Appends event to the chain whose last event has headSeq and headHash. Times
+// are kept to the millisecond, the coarsest precision of the supported
+// databases, so the hash still matches once the event has been read back.
+func _ChainEvent(event *models.AuthEvent, headSeq uint, headHash string) {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.CreatedAt = event.CreatedAt.UTC().Truncate(time.Millisecond)
+	event.Seq = headSeq + 1
+	event.Hash = HashEvent(headHash, *event)
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+	"strings"
+	"errors"
+)
+
+// Locking clause for a SELECT whose rows the transaction goes on to update. SQLite
+// doesn't support it and doesn't need it since dbhelper opens SQLite
+// transactions with the database write lock held.
+func _ForUpdate(tx *gorm.DB) string {
+	if tx.Dialector.Name() == "sqlite" {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// Reports whether err is a unique constraint violation and, when the driver says
+// so, the name of the key or constraint that was violated.
+func _DuplicateKey(err error) (string, bool) {
+	const MYSQL_DUPLICATE_ENTRY = 1062
+	const POSTGRES_UNIQUE_VIOLATION = "23505"
+	var mysqlErr *mysql.MySQLError
+	var postgresErr *pgconn.PgError
+	var sqliteErr sqlite3.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		if mysqlErr.Number != MYSQL_DUPLICATE_ENTRY {
+			return "", false
+		}
+		// Duplicate entry '...' for key 'users.email'
+		_, key, _ := strings.Cut(mysqlErr.Message, " for key ")
+		return strings.Trim(key, "'"), true
+	case errors.As(err, &postgresErr):
+		if postgresErr.Code != POSTGRES_UNIQUE_VIOLATION {
+			return "", false
+		}
+		// users_email_key
+		return postgresErr.ConstraintName, true
+	case errors.As(err, &sqliteErr):
+		if sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+			return "", false
+		}
+		// UNIQUE constraint failed: users.email
+		_, key, _ := strings.Cut(sqliteErr.Error(), "failed: ")
+		return key, true
+	}
+	return "", false
+}
+
+// Turns unique constraint violations on users into ErrEmailTaken or ErrDisplayNameTaken.
+func _UserError(err error) error {
+	key, ok := _DuplicateKey(err)
+	if !ok {
+		return err
+	}
+	// MySQL names the index, Postgres the constraint and SQLite the column
+	if strings.Contains(key, "display_name") {
+		return ErrDisplayNameTaken
+	} else if strings.Contains(key, "email") {
+		return ErrEmailTaken
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"gorm.io/gorm"
+	"errors"
+	"time"
+)
+
+// Keeps everything in the SQL database behind db.
+type GormStore struct {
+	gormQueries
+	// reads outside transactions go here, so they may lag behind db
+	readDB func() *gorm.DB
+}
+
+type gormTx struct {
+	gormQueries
+	done bool
+}
+
+// Queries shared by GormStore and its transactions.
+type gormQueries struct {
+	db *gorm.DB
+}
+
+// readDB picks the connection for reads outside transactions (e.g. a read replica),
+// pass nil to read from db.
+func NewGormStore(db *gorm.DB, readDB func() *gorm.DB) *GormStore {
+	if readDB == nil {
+		readDB = func() *gorm.DB {
+			return db
+		}
+	}
+	return &GormStore{gormQueries: gormQueries{db: db}, readDB: readDB}
+}
+
+func (s *GormStore) GetUserByID(id uint) (models.User, bool, error) {
+	return gormQueries{db: s.readDB()}.GetUserByID(id)
+}
+
+func (s *GormStore) GetUserByEmail(email string) (models.User, bool, error) {
+	return gormQueries{db: s.readDB()}.GetUserByEmail(email)
+}
+
+// Chaining takes a lock on the chain head, which needs a transaction.
+func (s *GormStore) RecordEvent(event *models.AuthEvent) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return gormQueries{db: tx}.RecordEvent(event)
+	})
+}
+
+func (s *GormStore) CountSessions() (int64, error) {
+	return gormQueries{db: s.readDB()}.CountSessions()
+}
+
+func (s *GormStore) Begin() (Tx, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &gormTx{gormQueries: gormQueries{db: tx}}, nil
+}
+
+func (t *gormTx) LockUserByEmail(email string) (models.User, bool, error) {
+	return t._GetUser("SELECT * FROM users WHERE email = ?" + _ForUpdate(t.db), email)
+}
+
+func (t *gormTx) LockUserByID(id uint) (models.User, bool, error) {
+	return t._GetUser("SELECT * FROM users WHERE id = ?" + _ForUpdate(t.db), id)
+}
+
+func (t *gormTx) Commit() error {
+	t.done = true
+	return t.db.Commit().Error
+}
+
+func (t *gormTx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	return t.db.Rollback().Error
+}
+
+func (q gormQueries) CreateUser(user *models.User) error {
+	return _UserError(q.db.Create(user).Error)
+}
+
+func (q gormQueries) GetUserByID(id uint) (models.User, bool, error) {
+	return q._GetUser("SELECT * FROM users WHERE id = ?", id)
+}
+
+func (q gormQueries) GetUserByEmail(email string) (models.User, bool, error) {
+	return q._GetUser("SELECT * FROM users WHERE email = ?", email)
+}
+
+func (q gormQueries) UpdateUser(user *models.User) error {
+	return _UserError(q.db.Save(user).Error)
+}
+
+func (q gormQueries) CreateSession(userID uint, tokenString string) error {
+	return q.db.Create(&models.RefreshToken{UserID: userID, TokenString: tokenString}).Error
+}
+
+func (q gormQueries) ReplaceSession(userID uint, oldTokenString, newTokenString string) (bool, error) {
+	result := q.db.Exec(
+		"UPDATE refresh_tokens SET token_string = ?, updated_at = ? WHERE token_string = ? AND user_id = ?",
+		newTokenString,
+		time.Now(),
+		oldTokenString,
+		userID,
+	)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (q gormQueries) DeleteSession(userID uint, tokenString string) (bool, error) {
+	result := q.db.Exec("DELETE FROM refresh_tokens WHERE token_string = ? AND user_id = ?", tokenString, userID)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (q gormQueries) DeleteSessions(userID uint) (int64, error) {
+	result := q.db.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", userID)
+	return result.RowsAffected, result.Error
+}
+
+func (q gormQueries) CountSessions() (int64, error) {
+	var count int64
+	err := q.db.Model(&models.RefreshToken{}).Count(&count).Error
+	return count, err
+}
+
+func (q gormQueries) CreateResetCode(resetCode *models.PasswordResetCode) error {
+	return q.db.Omit("User").Create(resetCode).Error
+}
+
+func (q gormQueries) GetResetCode(userID uint, code string) (models.PasswordResetCode, bool, error) {
+	var resetCode models.PasswordResetCode
+	result := q.db.Raw("SELECT * FROM password_reset_codes WHERE user_id = ? AND code = ?", userID, code).Scan(&resetCode)
+	return resetCode, result.RowsAffected > 0, result.Error
+}
+
+func (q gormQueries) DeleteResetCode(id uint) error {
+	return q.db.Exec("DELETE FROM password_reset_codes WHERE id = ?", id).Error
+}
+
+func (q gormQueries) DeleteResetCodes(userID uint) error {
+	return q.db.Exec("DELETE FROM password_reset_codes WHERE user_id = ?", userID).Error
+}
+
+// The chain head stays locked until the transaction ends, so transactions that
+// record events run one at a time from here on, across every server sharing the
+// database. That caps events at one per commit round trip (a few hundred a second
+// on a typical primary), and anything slow left in the transaction after this,
+// like a bcrypt hash, holds up every other login. Record the event last and
+// commit right after. Batching events would lift the cap but an event could then
+// be lost after the change it describes was committed.
+func (q gormQueries) RecordEvent(event *models.AuthEvent) error {
+	var head chainHead
+	result := q.db.Raw("SELECT seq, hash FROM auth_event_chain WHERE id = 1" + _ForUpdate(q.db)).Scan(&head)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("The audit log's chain head is missing.")
+	}
+	_ChainEvent(event, head.Seq, head.Hash)
+	err := q.db.Create(event).Error
+	if err != nil {
+		return err
+	}
+	return q.db.Exec("UPDATE auth_event_chain SET seq = ?, hash = ? WHERE id = 1", event.Seq, event.Hash).Error
+}
+
+func (q gormQueries) QueryEvents(filter EventFilter) ([]models.AuthEvent, error) {
+	var events []models.AuthEvent
+	query := q.db.Model(&models.AuthEvent{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if len(filter.Email) > 0 {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if len(filter.Type) > 0 {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	err := query.Order("created_at DESC, id DESC").Find(&events).Error
+	return events, err
+}
+
+func (q gormQueries) GetEventsAfter(afterSeq uint, limit int) ([]models.AuthEvent, error) {
+	var events []models.AuthEvent
+	err := q.db.Where("seq > ?", afterSeq).Order("seq").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (q gormQueries) GetChainHead() (uint, string, error) {
+	var head chainHead
+	err := q.db.Raw("SELECT seq, hash FROM auth_event_chain WHERE id = 1").Scan(&head).Error
+	return head.Seq, head.Hash, err
+}
+
+func (q gormQueries) CreateCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	return q.db.Create(checkpoint).Error
+}
+
+func (q gormQueries) GetLatestCheckpoint() (models.AuditCheckpoint, bool, error) {
+	var checkpoint models.AuditCheckpoint
+	result := q.db.Raw("SELECT * FROM audit_checkpoints ORDER BY number DESC LIMIT 1").Scan(&checkpoint)
+	return checkpoint, result.RowsAffected > 0, result.Error
+}
+
+func (q gormQueries) GetCheckpoints() ([]models.AuditCheckpoint, error) {
+	var checkpoints []models.AuditCheckpoint
+	err := q.db.Order("number").Find(&checkpoints).Error
+	return checkpoints, err
+}
+
+func (q gormQueries) _GetUser(query string, arg interface{}) (models.User, bool, error) {
+	var user models.User
+	result := q.db.Raw(query, arg).Scan(&user)
+	return user, result.RowsAffected > 0, result.Error
+}
+
+// The single row of auth_event_chain, the last event chained.
+type chainHead struct {
+	Seq uint
+	Hash string
+}
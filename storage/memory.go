@@ -0,0 +1,413 @@
+package storage
+
+import (
+	"github.com/shoppingapp/apiv1/models"
+	"sync"
+	"time"
+)
+
+// Keeps everything in process memory, for tests and demos. Transactions run one at
+// a time and are rolled back by restoring a copy of the data taken by Begin.
+type MemoryStore struct {
+	mu sync.Mutex
+	data *memoryData
+}
+
+type memoryTx struct {
+	*memoryData
+	store *MemoryStore
+	snapshot memoryData
+	done bool
+}
+
+type memoryData struct {
+	users map[uint]models.User
+	sessions map[uint]models.RefreshToken
+	resetCodes map[uint]models.PasswordResetCode
+	events []models.AuthEvent
+	checkpoints []models.AuditCheckpoint
+	lastID uint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: &memoryData{
+		users: map[uint]models.User{},
+		sessions: map[uint]models.RefreshToken{},
+		resetCodes: map[uint]models.PasswordResetCode{},
+	}}
+}
+
+func (s *MemoryStore) Begin() (Tx, error) {
+	// released by Commit or Rollback
+	s.mu.Lock()
+	return &memoryTx{memoryData: s.data, store: s, snapshot: s.data._Copy()}, nil
+}
+
+func (s *MemoryStore) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateUser(user)
+}
+
+func (s *MemoryStore) GetUserByID(id uint) (models.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetUserByID(id)
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (models.User, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetUserByEmail(email)
+}
+
+func (s *MemoryStore) UpdateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.UpdateUser(user)
+}
+
+func (s *MemoryStore) CreateSession(userID uint, tokenString string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateSession(userID, tokenString)
+}
+
+func (s *MemoryStore) ReplaceSession(userID uint, oldTokenString, newTokenString string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.ReplaceSession(userID, oldTokenString, newTokenString)
+}
+
+func (s *MemoryStore) DeleteSession(userID uint, tokenString string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteSession(userID, tokenString)
+}
+
+func (s *MemoryStore) DeleteSessions(userID uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteSessions(userID)
+}
+
+func (s *MemoryStore) CountSessions() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CountSessions()
+}
+
+func (s *MemoryStore) CreateResetCode(resetCode *models.PasswordResetCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateResetCode(resetCode)
+}
+
+func (s *MemoryStore) GetResetCode(userID uint, code string) (models.PasswordResetCode, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetResetCode(userID, code)
+}
+
+func (s *MemoryStore) DeleteResetCode(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteResetCode(id)
+}
+
+func (s *MemoryStore) DeleteResetCodes(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.DeleteResetCodes(userID)
+}
+
+func (s *MemoryStore) RecordEvent(event *models.AuthEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.RecordEvent(event)
+}
+
+func (s *MemoryStore) QueryEvents(filter EventFilter) ([]models.AuthEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.QueryEvents(filter)
+}
+
+func (s *MemoryStore) GetEventsAfter(afterSeq uint, limit int) ([]models.AuthEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetEventsAfter(afterSeq, limit)
+}
+
+func (s *MemoryStore) GetChainHead() (uint, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetChainHead()
+}
+
+func (s *MemoryStore) CreateCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.CreateCheckpoint(checkpoint)
+}
+
+func (s *MemoryStore) GetLatestCheckpoint() (models.AuditCheckpoint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetLatestCheckpoint()
+}
+
+func (s *MemoryStore) GetCheckpoints() ([]models.AuditCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.GetCheckpoints()
+}
+
+// Every transaction already holds the store's lock.
+func (t *memoryTx) LockUserByEmail(email string) (models.User, bool, error) {
+	return t.GetUserByEmail(email)
+}
+
+func (t *memoryTx) LockUserByID(id uint) (models.User, bool, error) {
+	return t.GetUserByID(id)
+}
+
+func (t *memoryTx) Commit() error {
+	if !t.done {
+		t.done = true
+		t.store.mu.Unlock()
+	}
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if !t.done {
+		t.done = true
+		*t.memoryData = t.snapshot
+		t.store.mu.Unlock()
+	}
+	return nil
+}
+
+func (d *memoryData) CreateUser(user *models.User) error {
+	err := d._CheckUnique(*user)
+	if err != nil {
+		return err
+	}
+	d.lastID++
+	user.ID = d.lastID
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	d.users[user.ID] = *user
+	return nil
+}
+
+func (d *memoryData) GetUserByID(id uint) (models.User, bool, error) {
+	user, ok := d.users[id]
+	return user, ok, nil
+}
+
+func (d *memoryData) GetUserByEmail(email string) (models.User, bool, error) {
+	for _, user := range d.users {
+		if user.Email == email {
+			return user, true, nil
+		}
+	}
+	return models.User{}, false, nil
+}
+
+func (d *memoryData) UpdateUser(user *models.User) error {
+	err := d._CheckUnique(*user)
+	if err != nil {
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	d.users[user.ID] = *user
+	return nil
+}
+
+func (d *memoryData) CreateSession(userID uint, tokenString string) error {
+	d.lastID++
+	now := time.Now()
+	session := models.RefreshToken{UserID: userID, TokenString: tokenString}
+	session.ID, session.CreatedAt, session.UpdatedAt = d.lastID, now, now
+	d.sessions[session.ID] = session
+	return nil
+}
+
+func (d *memoryData) ReplaceSession(userID uint, oldTokenString, newTokenString string) (bool, error) {
+	for id, session := range d.sessions {
+		if session.UserID == userID && session.TokenString == oldTokenString {
+			session.TokenString = newTokenString
+			session.UpdatedAt = time.Now()
+			d.sessions[id] = session
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *memoryData) DeleteSession(userID uint, tokenString string) (bool, error) {
+	for id, session := range d.sessions {
+		if session.UserID == userID && session.TokenString == tokenString {
+			delete(d.sessions, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *memoryData) DeleteSessions(userID uint) (int64, error) {
+	var deleted int64
+	for id, session := range d.sessions {
+		if session.UserID == userID {
+			delete(d.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (d *memoryData) CountSessions() (int64, error) {
+	return int64(len(d.sessions)), nil
+}
+
+func (d *memoryData) CreateResetCode(resetCode *models.PasswordResetCode) error {
+	d.lastID++
+	resetCode.ID = d.lastID
+	resetCode.CreatedAt = time.Now()
+	resetCode.UpdatedAt = resetCode.CreatedAt
+	d.resetCodes[resetCode.ID] = *resetCode
+	return nil
+}
+
+func (d *memoryData) GetResetCode(userID uint, code string) (models.PasswordResetCode, bool, error) {
+	for _, resetCode := range d.resetCodes {
+		if resetCode.UserID == userID && resetCode.Code == code {
+			return resetCode, true, nil
+		}
+	}
+	return models.PasswordResetCode{}, false, nil
+}
+
+func (d *memoryData) DeleteResetCode(id uint) error {
+	delete(d.resetCodes, id)
+	return nil
+}
+
+func (d *memoryData) DeleteResetCodes(userID uint) error {
+	for id, resetCode := range d.resetCodes {
+		if resetCode.UserID == userID {
+			delete(d.resetCodes, id)
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) RecordEvent(event *models.AuthEvent) error {
+	headSeq, headHash, _ := d.GetChainHead()
+	_ChainEvent(event, headSeq, headHash)
+	d.lastID++
+	event.ID = d.lastID
+	d.events = append(d.events, *event)
+	return nil
+}
+
+func (d *memoryData) QueryEvents(filter EventFilter) ([]models.AuthEvent, error) {
+	var events []models.AuthEvent
+	// events are appended in order, so walk back from the newest
+	for i := len(d.events) - 1; i >= 0; i-- {
+		event := d.events[i]
+		switch {
+		case filter.UserID != 0 && (event.UserID == nil || *event.UserID != filter.UserID):
+		case len(filter.Email) > 0 && event.Email != filter.Email:
+		case len(filter.Type) > 0 && event.Type != filter.Type:
+		case !filter.From.IsZero() && event.CreatedAt.Before(filter.From):
+		case !filter.To.IsZero() && !event.CreatedAt.Before(filter.To):
+		default:
+			events = append(events, event)
+		}
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (d *memoryData) GetEventsAfter(afterSeq uint, limit int) ([]models.AuthEvent, error) {
+	var events []models.AuthEvent
+	// events are appended in chain order
+	for _, event := range d.events {
+		if event.Seq > afterSeq && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (d *memoryData) GetChainHead() (uint, string, error) {
+	if len(d.events) == 0 {
+		return 0, "", nil
+	}
+	last := d.events[len(d.events)-1]
+	return last.Seq, last.Hash, nil
+}
+
+func (d *memoryData) CreateCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	d.lastID++
+	checkpoint.ID = d.lastID
+	if checkpoint.CreatedAt.IsZero() {
+		checkpoint.CreatedAt = time.Now()
+	}
+	d.checkpoints = append(d.checkpoints, *checkpoint)
+	return nil
+}
+
+func (d *memoryData) GetLatestCheckpoint() (models.AuditCheckpoint, bool, error) {
+	if len(d.checkpoints) == 0 {
+		return models.AuditCheckpoint{}, false, nil
+	}
+	return d.checkpoints[len(d.checkpoints)-1], true, nil
+}
+
+func (d *memoryData) GetCheckpoints() ([]models.AuditCheckpoint, error) {
+	return append([]models.AuditCheckpoint(nil), d.checkpoints...), nil
+}
+
+func (d *memoryData) _CheckUnique(user models.User) error {
+	for _, other := range d.users {
+		if other.ID == user.ID {
+			continue
+		}
+		if other.Email == user.Email {
+			return ErrEmailTaken
+		}
+		if other.DisplayName == user.DisplayName {
+			return ErrDisplayNameTaken
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) _Copy() memoryData {
+	copied := memoryData{
+		users: make(map[uint]models.User, len(d.users)),
+		sessions: make(map[uint]models.RefreshToken, len(d.sessions)),
+		resetCodes: make(map[uint]models.PasswordResetCode, len(d.resetCodes)),
+		// events and checkpoints are only ever appended, so the snapshot can share
+		// their arrays
+		events: d.events,
+		checkpoints: d.checkpoints,
+		lastID: d.lastID,
+	}
+	for id, user := range d.users {
+		copied.users[id] = user
+	}
+	for id, session := range d.sessions {
+		copied.sessions[id] = session
+	}
+	for id, resetCode := range d.resetCodes {
+		copied.resetCodes[id] = resetCode
+	}
+	return copied
+}
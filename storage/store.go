@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"github.com/shoppingapp/apiv1/attemptstore"
+	"github.com/shoppingapp/apiv1/models"
+	"errors"
+	"time"
+)
+
+// returned when creating or renaming a user would reuse an email or display name
+var ErrEmailTaken = errors.New("Email is already taken.")
+var ErrDisplayNameTaken = errors.New("Display name is already taken.")
+
+// Lookups return false instead of an error when nothing matches.
+type UserStore interface {
+	// Fills in the user's ID and timestamps.
+	CreateUser(user *models.User) error
+	GetUserByID(id uint) (models.User, bool, error)
+	GetUserByEmail(email string) (models.User, bool, error)
+	// Saves every field of user.
+	UpdateUser(user *models.User) error
+}
+
+// A session is a refresh token issued to a user.
+type SessionStore interface {
+	CreateSession(userID uint, tokenString string) error
+	// Swaps oldTokenString for newTokenString, returning false if the user has no
+	// session with oldTokenString.
+	ReplaceSession(userID uint, oldTokenString, newTokenString string) (bool, error)
+	// Deletes the user's session with tokenString, returning false if there's none.
+	DeleteSession(userID uint, tokenString string) (bool, error)
+	// Returns how many sessions were deleted.
+	DeleteSessions(userID uint) (int64, error)
+	// Returns how many sessions there are across all users.
+	CountSessions() (int64, error)
+}
+
+type ResetCodeStore interface {
+	// Fills in the code's ID and timestamps.
+	CreateResetCode(resetCode *models.PasswordResetCode) error
+	GetResetCode(userID uint, code string) (models.PasswordResetCode, bool, error)
+	DeleteResetCode(id uint) error
+	DeleteResetCodes(userID uint) error
+}
+
+type AuditStore interface {
+	// Fills in the event's ID and its CreatedAt when unset, and appends it to the
+	// hash chain by setting Seq and Hash.
+	RecordEvent(event *models.AuthEvent) error
+	// Returns the matching events, newest first.
+	QueryEvents(filter EventFilter) ([]models.AuthEvent, error)
+	// Returns up to limit events with a Seq above afterSeq, in chain order.
+	GetEventsAfter(afterSeq uint, limit int) ([]models.AuthEvent, error)
+	// Returns the Seq and Hash of the last event chained, 0 and "" before the first.
+	GetChainHead() (uint, string, error)
+	// Fills in the checkpoint's ID, and its CreatedAt when unset.
+	CreateCheckpoint(checkpoint *models.AuditCheckpoint) error
+	// Returns the checkpoint with the highest Number.
+	GetLatestCheckpoint() (models.AuditCheckpoint, bool, error)
+	// Returns every checkpoint by Number.
+	GetCheckpoints() ([]models.AuditCheckpoint, error)
+}
+
+// Zero fields match every event.
+type EventFilter struct {
+	UserID uint
+	Email string
+	Type string
+	// From is inclusive and To exclusive
+	From time.Time
+	To time.Time
+	Limit int
+}
+
+// Counts failed attempts for lockouts and credential stuffing defenses, see the
+// attemptstore package for its memory and Redis implementations.
+type AttemptStore = attemptstore.Store
+
+// Everything persisted about accounts apart from attempt counters.
+type Store interface {
+	UserStore
+	SessionStore
+	ResetCodeStore
+	AuditStore
+	// Defer Rollback right after Begin; it does nothing once Commit has been called.
+	Begin() (Tx, error)
+}
+
+type Tx interface {
+	UserStore
+	SessionStore
+	ResetCodeStore
+	AuditStore
+	// Like GetUserByEmail, but other transactions can't change the user until this one ends.
+	LockUserByEmail(email string) (models.User, bool, error)
+	// Like GetUserByID, but other transactions can't change the user until this one ends.
+	LockUserByID(id uint) (models.User, bool, error)
+	Commit() error
+	Rollback() error
+}
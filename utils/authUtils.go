@@ -1,12 +1,19 @@
 package utils
 
 import (
+	"github.com/shoppingapp/apiv1/config"
+	"github.com/shoppingapp/apiv1/metrics"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/golang-jwt/jwt"
 	"github.com/xlzd/gotp"
 	"encoding/base64"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/rand"
+	"math"
 	"time"
-	"os"
+	"net"
 	"fmt"
 	"errors"
 )
@@ -15,43 +22,106 @@ var secretLength int = 16
 var totp *gotp.TOTP = gotp.NewDefaultTOTP(gotp.RandomSecret(secretLength))
 
 func HashPassword(password string) (string, error) {
+	defer metrics.ObserveBcrypt("hash", time.Now())
 	const HASH_ROUNDS = 10
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), HASH_ROUNDS)
 	return string(bytes), err
 }
 
 func ComparePasswords(hashedPassword, password string) error {
+	defer metrics.ObserveBcrypt("compare", time.Now())
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
+var jwtConfig config.JWTConfig
+
+func SetJWTConfig(cfg config.JWTConfig) {
+	jwtConfig = cfg
+}
+
 func _GetJWTSecret(tokenType string, getOldKey bool) ([]byte, error) {
 	var b64String string
 	if tokenType == REFRESH_TYPE {
-		b64String = os.Getenv(JWT_SECRET_KEY_REFRESH)
+		b64String = jwtConfig.RefreshSecret
 		if getOldKey {
-			b64String = os.Getenv(JWT_SECRET_KEY_REFRESH_OLD)	
+			b64String = jwtConfig.RefreshSecretOld
 		}
 	} else {
-		b64String = os.Getenv(JWT_SECRET_KEY_ACCESS)
+		b64String = jwtConfig.AccessSecret
 		if getOldKey {
-			b64String = os.Getenv(JWT_SECRET_KEY_ACCESS_OLD)	
+			b64String = jwtConfig.AccessSecretOld
 		}
 	}
 	return base64.StdEncoding.DecodeString(b64String)
 }
 
-func CreateJWTToken(displayName, tokenType string) (string, error) {
+// Fails unless both current secrets decode to a key tokens can be signed with.
+func CheckJWTKeys() error {
+	for _, tokenType := range []string{ACCESS_TYPE, REFRESH_TYPE} {
+		signingKey, err := _GetJWTSecret(tokenType, false)
+		if err != nil {
+			return err
+		}
+		if len(signingKey) == 0 {
+			return errors.New(fmt.Sprintf("The %s token secret is not set.", tokenType))
+		}
+	}
+	return nil
+}
+
+func GenerateJWTSecret() (string, error) {
+	const SECRET_BYTES = 32
+	bytes := make([]byte, SECRET_BYTES)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bytes), nil
+}
+
+// Returns a base64 Ed25519 seed for audit.signingKey and the matching public key
+// for audit.publicKey.
+func GenerateSigningKey() (string, string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(privateKey.Seed()), base64.StdEncoding.EncodeToString(publicKey), nil
+}
+
+// Random ID for a session, which keeps it across refresh token rotations, or for a
+// single token.
+func GenerateTokenID() (string, error) {
+	const ID_BYTES = 16
+	bytes := make([]byte, ID_BYTES)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Tokens name their user by ID, which unlike the display name never changes hands,
+// so renaming leaves sessions alone. Both tokens of a session carry its ID, and
+// each token has an ID of its own so no two are alike.
+func CreateJWTToken(userID uint, tokenType, sessionID string) (string, error) {
 	signingKey, err := _GetJWTSecret(tokenType, false)
 	if err != nil {
 		return "", err
 	}
+	tokenID, err := GenerateTokenID()
+	if err != nil {
+		return "", err
+	}
 	claims := jwt.MapClaims{}
-	claims["displayName"] = displayName
+	claims["userId"] = userID
 	claims["tokenType"] = tokenType
+	claims["sid"] = sessionID
+	claims["jti"] = tokenID
 	if tokenType == REFRESH_TYPE {
-		claims["exp"] = time.Now().Add(time.Hour * 24 * REFRESH_TOKEN_DURATION).Unix()
+		claims["exp"] = time.Now().Add(jwtConfig.RefreshTokenDuration).Unix()
 	} else {
-		claims["exp"] = time.Now().Add(time.Minute * ACCESS_TOKEN_DURATION).Unix()
+		claims["exp"] = time.Now().Add(jwtConfig.AccessTokenDuration).Unix()
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(signingKey)
@@ -61,6 +131,57 @@ func CreateJWTToken(displayName, tokenType string) (string, error) {
 	return tokenString, nil
 }
 
+// Reads the sid claim of a verified token, empty for tokens issued before sessions
+// had IDs.
+func GetSessionID(claims jwt.MapClaims) string {
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
+
+// The CSRF token of a session, an HMAC of its ID under the refresh token secret.
+// Only the server can derive it, so a cookie another site sets can't stand in
+// for it.
+func CreateCSRFToken(sessionID string) (string, error) {
+	signingKey, err := _GetJWTSecret(REFRESH_TYPE, false)
+	if err != nil {
+		return "", err
+	}
+	return _CSRFToken(signingKey, sessionID), nil
+}
+
+// Checks csrfToken against the session's, derived with the current or the old
+// refresh token secret.
+func CheckCSRFToken(sessionID, csrfToken string) bool {
+	if len(sessionID) == 0 || len(csrfToken) == 0 {
+		return false
+	}
+	for _, getOldKey := range []bool{false, true} {
+		signingKey, err := _GetJWTSecret(REFRESH_TYPE, getOldKey)
+		if err != nil || len(signingKey) == 0 {
+			continue
+		}
+		if hmac.Equal([]byte(_CSRFToken(signingKey, sessionID)), []byte(csrfToken)) {
+			return true
+		}
+	}
+	return false
+}
+
+func _CSRFToken(signingKey []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Reads the userId claim of a verified token. JSON numbers decode as float64.
+func GetUserID(claims jwt.MapClaims) (uint, bool) {
+	userID, ok := claims["userId"].(float64)
+	if !ok || userID < 1 || userID != math.Trunc(userID) {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
 func _ParseJWTToken(tokenString string, signingKey []byte) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -80,7 +201,11 @@ func VerifyJWTToken(tokenType, tokenString string) (jwt.MapClaims, error, string
 		return jwt.MapClaims{}, err, SERVER_DOWN
 	}
 	token1, errCurrentSecret := _ParseJWTToken(tokenString, signingKey)
-	token2, errOldSecret := _ParseJWTToken(tokenString, oldSigningKey)
+	token2, errOldSecret := token1, errCurrentSecret
+	// an unset old secret must not accept tokens signed with an empty key
+	if len(oldSigningKey) > 0 {
+		token2, errOldSecret = _ParseJWTToken(tokenString, oldSigningKey)
+	}
 	if errCurrentSecret != nil && errOldSecret != nil {
 		return jwt.MapClaims{}, errCurrentSecret, JWT_TOKEN_PARSING_ERROR
 	}
@@ -98,11 +223,36 @@ func GetVerificationCode() string {
 }
 
 func GenerateBanMessage(banExpAt time.Time) string {
-	diff := banExpAt.Sub(time.Now())
-	timeLeft := int(diff.Round(time.Minute).Minutes())
-	errorMessage := fmt.Sprintf("Please try again in %d minutes.", timeLeft)
-	if timeLeft <= 1 {
-		errorMessage = fmt.Sprintf("Please try again in 1 minute.")
+	// round up so clients never retry before the ban lifts
+	minutesLeft := int(math.Ceil(time.Until(banExpAt).Minutes()))
+	if minutesLeft <= 1 {
+		return "Please try again in 1 minute."
+	}
+	hoursLeft, minutesLeft := minutesLeft / 60, minutesLeft % 60
+	if hoursLeft == 0 {
+		return fmt.Sprintf("Please try again in %d minutes.", minutesLeft)
+	}
+	timeLeft := fmt.Sprintf("%d hour", hoursLeft)
+	if hoursLeft > 1 {
+		timeLeft += "s"
+	}
+	if minutesLeft == 1 {
+		timeLeft += " and 1 minute"
+	} else if minutesLeft > 1 {
+		timeLeft += fmt.Sprintf(" and %d minutes", minutesLeft)
+	}
+	return fmt.Sprintf("Please try again in %s.", timeLeft)
+}
+
+// Groups nearby addresses so attackers rotating through one network share a counter:
+// IPv4 addresses map to their /24 and IPv6 addresses to their /48.
+func GetSubnet(ip string) string {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ip
+	}
+	if ipv4 := parsedIP.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
 	}
-	return errorMessage
+	return (&net.IPNet{IP: parsedIP.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
 }
\ No newline at end of file
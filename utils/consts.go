@@ -1,19 +1,16 @@
 package utils
 
-// environment variables
-const DBUSER = "DBUSER"
-const DBPASS = "DBUSER"
-const DBNAME = "DBNAME"
+// JWT secret env vars, rewritten by authctl rotate-keys
 const JWT_SECRET_KEY_ACCESS = "JWT_SECRET_KEY_ACCESS"
 const JWT_SECRET_KEY_REFRESH = "JWT_SECRET_KEY_REFRESH"
 const JWT_SECRET_KEY_ACCESS_OLD = "JWT_SECRET_KEY_ACCESS_OLD"
 const JWT_SECRET_KEY_REFRESH_OLD = "JWT_SECRET_KEY_REFRESH_OLD"
+const AUDIT_SIGNING_KEY = "AUDIT_SIGNING_KEY"
+const AUDIT_PUBLIC_KEY = "AUDIT_PUBLIC_KEY"
 const ACCESS_TYPE = "access"
 const REFRESH_TYPE = "refresh"
 
 // error messages
-const GORM_ERR_CODE_DUPLICATE_KEY = "Error 1062"
-
 const SERVER_DOWN = "The server is having some time processing this request right now. Please try again in a bit!"
 
 const MISSING_REQUEST_DATA = "Your request is missing a few things. Please try again!"
@@ -28,17 +25,14 @@ const GENERIC_SIGNUP_ERROR = "We had some trouble signing you up. Please try aga
 const GENERIC_LOGIN_ERROR = "We had some trouble logging you in. Please try again!"
 const GENERIC_PASSWORD_RESET_REQUEST_ERROR = "We had some trouble getting you a verification code. Please try again!"
 const GENERIC_PASSWORD_RESET_ERROR = "We had some trouble resetting your password. Please try again!"
+const GENERIC_PROFILE_ERROR = "We had some trouble loading your profile. Please try again!"
+const GENERIC_PROFILE_UPDATE_ERROR = "We had some trouble updating your profile. Please try again!"
+const CAPTCHA_REQUIRED_ERROR = "We've seen some unusual activity. Please complete the captcha and try again!"
+const TOO_MANY_REQUESTS_ERROR = "You're sending requests too quickly. Please slow down and try again in a bit!"
 const GENERIC_RATE_LIMIT_ERROR = "We had some trouble getting you a verification code. Please try again!"
-
-// ban durations
-const MAX_NUM_PASS_RESET_CODES = 10
-const MAX_NUM_PASS_RESET_ATTEMPTS = 10
-const MAX_NUM_LOGIN_ATTEMPTS = 5
-
-const REFRESH_TOKEN_DURATION = 7 // 7 days
-const ACCESS_TOKEN_DURATION = 15 // 15 minutes
-const CODE_DURATION = 20 // 20 minutes
-
-const LOGIN_BAN_DURATION = 10
-const RESET_PASSWORD_REQUEST_BAN_DURATION = 10
-const RESET_PASSWORD_BAN_DURATION = 10
\ No newline at end of file
+const ADMIN_REQUIRED_ERROR = "You need to be an admin to do that."
+const INVALID_AUDIT_QUERY_ERROR = "We couldn't understand that audit log query."
+const GENERIC_AUDIT_ERROR = "We had some trouble loading the audit log. Please try again!"
+const CLIENT_CERT_REQUIRED_ERROR = "This endpoint needs a client certificate."
+const HTTPS_REQUIRED_ERROR = "This API only accepts requests over HTTPS."
+const CSRF_FAILED_ERROR = "Your session couldn't be verified. Please refresh the page and try again."
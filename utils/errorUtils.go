@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"net/http"
+	"encoding/json"
+	"strconv"
+	"math"
+	"time"
+)
+
+// Stable error codes for clients to branch on, unlike the messages which may be
+// reworded at any time.
+const ERROR_CODE_MALFORMED_REQUEST = "malformed_request"
+const ERROR_CODE_VALIDATION_FAILED = "validation_failed"
+const ERROR_CODE_INVALID_QUERY = "invalid_query"
+const ERROR_CODE_INVALID_CREDENTIALS = "invalid_credentials"
+const ERROR_CODE_INVALID_TOKEN = "invalid_token"
+const ERROR_CODE_TOKEN_EXPIRED = "token_expired"
+const ERROR_CODE_FORBIDDEN = "forbidden"
+const ERROR_CODE_CSRF_FAILED = "csrf_failed"
+const ERROR_CODE_EMAIL_TAKEN = "email_taken"
+const ERROR_CODE_DISPLAY_NAME_TAKEN = "display_name_taken"
+const ERROR_CODE_CAPTCHA_REQUIRED = "captcha_required"
+const ERROR_CODE_LOCKED_OUT = "locked_out"
+const ERROR_CODE_RATE_LIMITED = "rate_limited"
+const ERROR_CODE_INTERNAL = "internal_error"
+
+// A request body field that failed validation, Rule is the failed validate tag
+// (e.g. "email" or "min") and Param its parameter, if any.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// How close a login, password reset request or reset attempt is to a lockout.
+// BannedUntil is only set once it's locked out.
+type LockoutDetails struct {
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"`
+	AttemptsRemaining int `json:"attemptsRemaining"`
+}
+
+type APIError struct {
+	Status int `json:"-"`
+	Code string `json:"code"`
+	Message string `json:"message"`
+	// whether resending the same request may succeed later
+	Retryable bool `json:"retryable"`
+	Fields []FieldError `json:"fields,omitempty"`
+	Lockout *LockoutDetails `json:"lockout,omitempty"`
+}
+
+var errorStatuses = map[string]int{
+	ERROR_CODE_MALFORMED_REQUEST: http.StatusBadRequest,
+	ERROR_CODE_VALIDATION_FAILED: http.StatusUnprocessableEntity,
+	ERROR_CODE_INVALID_QUERY: http.StatusBadRequest,
+	ERROR_CODE_INVALID_CREDENTIALS: http.StatusUnauthorized,
+	ERROR_CODE_INVALID_TOKEN: http.StatusUnauthorized,
+	ERROR_CODE_TOKEN_EXPIRED: http.StatusUnauthorized,
+	ERROR_CODE_FORBIDDEN: http.StatusForbidden,
+	ERROR_CODE_CSRF_FAILED: http.StatusForbidden,
+	ERROR_CODE_EMAIL_TAKEN: http.StatusConflict,
+	ERROR_CODE_DISPLAY_NAME_TAKEN: http.StatusConflict,
+	ERROR_CODE_CAPTCHA_REQUIRED: http.StatusPreconditionRequired,
+	ERROR_CODE_LOCKED_OUT: http.StatusTooManyRequests,
+	ERROR_CODE_RATE_LIMITED: http.StatusTooManyRequests,
+	ERROR_CODE_INTERNAL: http.StatusInternalServerError,
+}
+
+// The status an error code is sent with, 500 for unknown codes.
+func ErrorStatus(code string) int {
+	status, ok := errorStatuses[code]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+func NewAPIError(status int, code, message string) APIError {
+	retryable := status == http.StatusPreconditionRequired || status == http.StatusTooManyRequests || status >= 500
+	return APIError{Status: status, Code: code, Message: message, Retryable: retryable}
+}
+
+// Writes apiErr as {"error": {"code": ..., "message": ..., "fields": [...]}} with
+// its status, and a Retry-After header for lockouts.
+func WriteError(w http.ResponseWriter, apiErr APIError) {
+	if apiErr.Lockout != nil && apiErr.Lockout.BannedUntil != nil {
+		// round up so clients never retry before the ban lifts
+		seconds := int(math.Ceil(time.Until(*apiErr.Lockout.BannedUntil).Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: apiErr})
+}